@@ -1,28 +1,56 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log/slog"
 	"math/rand"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+var (
+	logLevel  = flag.String("log-level", "info", "debug, info, warn or error")
+	logFormat = flag.String("log-format", "text", "text or json")
+)
+
+// setupLogging sends slog to stderr at --log-level in --log-format
+// Results and final counts still go to stdout with fmt, the logs are what
+// happened on the way
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("--log-level %q: must be debug, info, warn or error", *logLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch *logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return fmt.Errorf("--log-format %q: must be text or json", *logFormat)
+	}
+	return nil
+}
+
 // worker thread, reads from jobs, writes to results channel
 func worker(id int, jobs <-chan int, results chan<- int) {
 	for j := range jobs {
-		fmt.Println("worker", id, "started  job", j)
+		slog.Info("started job", "worker", id, "job", j)
 		time.Sleep(time.Second)
-		fmt.Println("worker", id, "finished job", j)
+		slog.Info("finished job", "worker", id, "job", j)
 		results <- j * 2
 	}
 }
 
 func workerSynced(id int, jobs <-chan int, results chan<- int) {
 	for j := range jobs {
-		fmt.Println("Synced worker", id, "started  job", j)
+		slog.Info("started job", "worker", id, "job", j, "synced", true)
 		time.Sleep(time.Second)
-		fmt.Println("Synced worker", id, "finished job", j)
+		slog.Info("finished job", "worker", id, "job", j, "synced", true)
 		results <- j * 2
 	}
 	wg.Done()
@@ -96,8 +124,7 @@ func rateLimiting() {
 	limiter := time.Tick(1 * time.Second)
 	for req := range requests {
 		<-limiter // Blocks for 1 s
-		fmt.Println("request generated at ", req)
-		fmt.Println("request drained at ", time.Now())
+		slog.Info("request drained", "generated", req, "drained", time.Now())
 	}
 
 	// Generate bursty Limiter to allow burst of N reqs before rate Limiting
@@ -118,8 +145,7 @@ func rateLimiting() {
 	close(burstyReqs)
 	for req := range burstyReqs {
 		<-burstyLimiter // Initially allows 3 request burst before being limited
-		fmt.Println("request generated at ", req)
-		fmt.Println("request drained at ", time.Now())
+		slog.Info("request drained", "generated", req, "drained", time.Now(), "bursty", true)
 	}
 
 }
@@ -293,6 +319,12 @@ func shareStateViaGoRoutines() {
 }
 
 func main() {
+	flag.Parse()
+	if err := setupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	workerPool()
 	workerPoolResCombined()
 	rateLimiting()
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	refreshInterval = flag.Duration("refresh-interval", 5*time.Minute, "how often to aggregate in the background, 0 to only aggregate on requests")
+	maxAge          = flag.Duration("max-age", 0, "serve /agg/ from the last aggregation if it is younger than this, 0 to aggregate on every request")
+	staleAfter      = flag.Duration("stale-after", 30*time.Minute, "report not ready if there was no successful aggregation for this long")
+	historySize     = flag.Int("history", 5000, "articles kept after they drop out of the sitemaps so their /news/{id} pages keep working, 0 to forget them straight away")
+)
+
+// sourceStatus is the outcome of the latest fetches of one sitemap
+type sourceStatus struct {
+	LastSuccess time.Time `json:"last_success,omitzero"`
+	LastFailure time.Time `json:"last_failure,omitzero"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Failing if the most recent attempt failed
+func (s sourceStatus) failing() bool {
+	return s.LastFailure.After(s.LastSuccess)
+}
+
+// aggregator keeps the most recent aggregation and how each source is doing
+type aggregator struct {
+	mu          sync.RWMutex
+	newsMap     map[string]NewsMap
+	byID        map[string]string // article ID to title
+	refreshedAt time.Time         // last successful aggregation
+	modifiedAt  time.Time         // last aggregation that changed anything, for Last-Modified
+	sources     map[string]sourceStatus
+	summaries   map[string]string // article ID to summary, filled in by the enricher
+	clusters    map[string]string // title to story key, for articles with near duplicates
+
+	// Articles that dropped out of the sitemaps, by ID, up to --history of them
+	history      map[string]pastArticle
+	historyOrder []string // IDs in history, the oldest drop first
+
+	// In-flight sitemap fetches, keyed by URL
+	indexFlights flightGroup[SitemapIndex]
+	newsFlights  flightGroup[News]
+}
+
+// The one aggregator shared by the handlers and the background loop
+var agg = &aggregator{sources: make(map[string]sourceStatus)}
+
+var errNoArticles = errors.New("no articles aggregated")
+
+// news returns the aggregated news, aggregating again unless the last
+// result is younger than --max-age
+// If aggregating fails the last good result is served instead
+func (a *aggregator) news(ctx context.Context) (map[string]NewsMap, error) {
+	a.mu.RLock()
+	newsMap, refreshedAt := a.newsMap, a.refreshedAt
+	a.mu.RUnlock()
+	if newsMap != nil && time.Since(refreshedAt) < *maxAge {
+		return newsMap, nil
+	}
+
+	fresh, err := a.refresh(ctx)
+	if err != nil {
+		if newsMap != nil {
+			slog.Warn("aggregation failed, serving previous results", "refreshed_at", refreshedAt, "err", err)
+			return newsMap, nil
+		}
+		return nil, err
+	}
+	return fresh, nil
+}
+
+// refresh fetches the sitemap index then every sitemap concurrently
+// and stores the merged result
+func (a *aggregator) refresh(ctx context.Context) (map[string]NewsMap, error) {
+	// Parse XML
+	// Requests refreshing at the same time share the one fetch
+	siteMapIndexObj, shared, err := a.indexFlights.do(ctx, *sitemapIndexURL, func(ctx context.Context) (SitemapIndex, error) {
+		var index SitemapIndex
+		err := fetchXML(ctx, *sitemapIndexURL, &index)
+		a.recordSource(*sitemapIndexURL, err)
+		return index, err
+	})
+	if shared {
+		sitemapFetchShared.Inc(*sitemapIndexURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	newsMap := make(map[string]NewsMap)
+
+	// Channel to push News Objects into
+	// Room for every sitemap so no routine blocks before we start reading
+	queue := make(chan sitemapResult, len(siteMapIndexObj.Locations))
+
+	// Call the Go Routines to concurrently pull Info from each XML
+	var wg sync.WaitGroup
+	for _, Location := range siteMapIndexObj.Locations {
+		wg.Add(1)
+		go a.newsRoutine(ctx, &wg, queue, strings.TrimSpace(Location))
+	}
+
+	// Wait for channel Buffer to fill, then close it
+	wg.Wait()
+	close(queue)
+
+	// Cancelled fetches look like empty sitemaps, don't store what's left
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Iterate the Channel to get news Type Objects
+	failed := make(map[string]bool)
+	for elem := range queue {
+		if elem.err != nil {
+			failed[elem.Source] = true
+			continue
+		}
+		// Each News Object has a number of articles
+		for _, u := range elem.URLs {
+			if u.Title == "" {
+				continue
+			}
+			location := strings.TrimSpace(u.Location)
+			newsMap[u.Title] = NewsMap{
+				Keyword:   u.Keywords,
+				Location:  location,
+				ID:        articleID(location),
+				Source:    elem.Source,
+				Published: parsePublished(u.PublicationDate),
+				Language:  articleLanguage(u.Language, u.Title, u.Keywords),
+			}
+		}
+	}
+
+	// A sitemap that failed this time keeps its articles from the last one,
+	// else they'd drop out now and be published as new when it's back
+	a.mu.RLock()
+	for title, data := range a.newsMap {
+		if _, ok := newsMap[title]; !ok && failed[data.Source] {
+			newsMap[title] = data
+		}
+	}
+	a.mu.RUnlock()
+
+	refreshesTotal.Inc()
+	refreshArticles.Set(float64(len(newsMap)))
+	slog.Info("aggregation finished", "sources", len(siteMapIndexObj.Locations), "failed", len(failed), "articles", len(newsMap))
+	if len(newsMap) == 0 {
+		return nil, errNoArticles
+	}
+
+	// Worked out before taking the lock, it compares a lot of articles
+	clusters := clusterArticles(newsMap, *clusterThreshold)
+	a.mu.Lock()
+	added := a.store(newsMap, time.Now())
+	a.clusters = clusters
+	a.mu.Unlock()
+
+	// Tell the live subscribers about anything we haven't seen before
+	broker.publish(added)
+	if alerts != nil {
+		alerts.evaluate(newsMap)
+	}
+	if enrich != nil {
+		enrich.enqueue(newsMap)
+	}
+	return newsMap, nil
+}
+
+// pastArticle is an article that's no longer in the sitemaps
+type pastArticle struct {
+	Title   string
+	Data    NewsMap
+	Dropped time.Time
+}
+
+// store swaps in a new aggregation, carrying over when each article was
+// first seen. Articles that dropped out of the sitemaps move to the history
+// Returns the articles that are new since the last aggregation, nothing
+// on the first one as then everything is new
+// Must be called with a.mu held
+func (a *aggregator) store(newsMap map[string]NewsMap, now time.Time) []NewsEvent {
+	firstSeen := make(map[string]time.Time, len(a.newsMap)+len(a.history))
+	for id, past := range a.history {
+		firstSeen[id] = past.Data.FirstSeen
+	}
+	for _, data := range a.newsMap {
+		firstSeen[data.ID] = data.FirstSeen
+	}
+
+	var added []NewsEvent
+	changed := len(newsMap) != len(a.newsMap)
+	byID := make(map[string]string, len(newsMap))
+	for title, data := range newsMap {
+		data.FirstSeen = now
+		if seen, ok := firstSeen[data.ID]; ok {
+			data.FirstSeen = seen
+		} else if a.newsMap != nil {
+			added = append(added, newNewsEvent(title, data))
+		}
+		if old, ok := a.newsMap[title]; !ok || !sameArticle(old, data) {
+			changed = true
+		}
+		newsMap[title] = data
+		byID[data.ID] = title
+	}
+
+	a.remember(byID, now)
+
+	// Summaries of articles that are gone for good aren't needed
+	for id := range a.summaries {
+		_, current := byID[id]
+		_, past := a.history[id]
+		if !current && !past {
+			delete(a.summaries, id)
+		}
+	}
+
+	a.newsMap = newsMap
+	a.byID = byID
+	a.refreshedAt = now
+	if changed {
+		a.modifiedAt = now
+	}
+	return added
+}
+
+// remember moves the articles missing from byID into the history and takes
+// out any that are back, then forgets the oldest past --history of them
+// Must be called with a.mu held, before a.newsMap is replaced
+func (a *aggregator) remember(byID map[string]string, now time.Time) {
+	if a.history == nil {
+		a.history = make(map[string]pastArticle)
+	}
+	back := false
+	for id := range a.history {
+		if _, ok := byID[id]; ok {
+			delete(a.history, id)
+			back = true
+		}
+	}
+	if back {
+		a.historyOrder = slices.DeleteFunc(a.historyOrder, func(id string) bool {
+			_, ok := a.history[id]
+			return !ok
+		})
+	}
+
+	// Ones dropped together go in first seen order, then by title, so which
+	// of them get forgotten first doesn't depend on map order
+	var dropped []pastArticle
+	for title, data := range a.newsMap {
+		if _, ok := byID[data.ID]; !ok {
+			dropped = append(dropped, pastArticle{Title: title, Data: data, Dropped: now})
+		}
+	}
+	slices.SortFunc(dropped, func(x, y pastArticle) int {
+		if c := x.Data.FirstSeen.Compare(y.Data.FirstSeen); c != 0 {
+			return c
+		}
+		return strings.Compare(x.Title, y.Title)
+	})
+	for _, past := range dropped {
+		a.history[past.Data.ID] = past
+		a.historyOrder = append(a.historyOrder, past.Data.ID)
+	}
+
+	if over := len(a.historyOrder) - *historySize; over > 0 {
+		for _, id := range a.historyOrder[:over] {
+			delete(a.history, id)
+		}
+		a.historyOrder = slices.Delete(a.historyOrder, 0, over)
+	}
+}
+
+// Times are compared with Equal, parsed zones aren't the same pointer twice
+func sameArticle(a, b NewsMap) bool {
+	return a.Keyword == b.Keyword && a.Location == b.Location && a.ID == b.ID &&
+		a.Source == b.Source && a.Language == b.Language && a.Published.Equal(b.Published) && a.FirstSeen.Equal(b.FirstSeen)
+}
+
+// lastModified is when the aggregated news last changed
+func (a *aggregator) lastModified() time.Time {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.modifiedAt
+}
+
+// article looks up an article of the last aggregation by ID, or in the
+// history if it has dropped out of the sitemaps since, then dropped says when
+func (a *aggregator) article(id string) (title string, data NewsMap, dropped time.Time, ok bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if title, ok := a.byID[id]; ok {
+		return title, a.newsMap[title], time.Time{}, true
+	}
+	if past, ok := a.history[id]; ok {
+		return past.Title, past.Data, past.Dropped, true
+	}
+	return "", NewsMap{}, time.Time{}, false
+}
+
+// withHistory adds the articles in the history to newsMap, for exports
+// that reach back further than the sitemaps do
+func (a *aggregator) withHistory(newsMap map[string]NewsMap) map[string]NewsMap {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	all := make(map[string]NewsMap, len(newsMap)+len(a.history))
+	for _, past := range a.history {
+		all[past.Title] = past.Data
+	}
+	for title, data := range newsMap {
+		all[title] = data
+	}
+	return all
+}
+
+// setSummary stores an article's summary, the page counts as changed
+func (a *aggregator) setSummary(id, summary string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.byID[id]; !ok {
+		return
+	}
+	if a.summaries == nil {
+		a.summaries = make(map[string]string)
+	}
+	a.summaries[id] = summary
+	a.modifiedAt = time.Now()
+}
+
+// summary is the article's summary, "" if there isn't one (yet)
+func (a *aggregator) summary(id string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.summaries[id]
+}
+
+// clusterKeys groups the articles of the last aggregation into stories, see clusterArticles
+func (a *aggregator) clusterKeys() map[string]string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.clusters
+}
+
+// snapshot returns the last aggregation without fetching anything
+func (a *aggregator) snapshot() map[string]NewsMap {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.newsMap
+}
+
+// sitemapResult is one sitemap's news, or why it couldn't be fetched
+type sitemapResult struct {
+	News
+	err error
+}
+
+// Go routine to pull the news objects
+func (a *aggregator) newsRoutine(ctx context.Context, wg *sync.WaitGroup, channelObj chan<- sitemapResult, location string) {
+	defer wg.Done()
+	fetchWorkers.Inc()
+	defer fetchWorkers.Dec()
+
+	// Create a news Obj from response Data
+	// Shared with any other refresh fetching the same sitemap right now
+	newsObj, shared, err := a.newsFlights.do(ctx, location, func(ctx context.Context) (News, error) {
+		newsObj := News{Source: location}
+		err := fetchXML(ctx, location, &newsObj)
+		a.recordSource(location, err)
+		return newsObj, err
+	})
+	if shared {
+		sitemapFetchShared.Inc(location)
+	}
+	if err != nil {
+		// One broken sitemap shouldn't take the whole page down
+		slog.Warn("skipping sitemap", "source", location, "err", err)
+		channelObj <- sitemapResult{News: News{Source: location}, err: err}
+		return
+	}
+
+	// Fill the News Objects into the Channel
+	channelObj <- sitemapResult{News: newsObj}
+}
+
+func (a *aggregator) recordSource(location string, err error) {
+	// A client going away says nothing about the source
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.sources[location]
+	if err != nil {
+		s.LastFailure = time.Now()
+		s.LastError = err.Error()
+	} else {
+		s.LastSuccess = time.Now()
+		s.LastError = ""
+	}
+	a.sources[location] = s
+}
+
+// run aggregates straight away and then every interval, so the page and
+// the readiness check have data before anyone asks
+func (a *aggregator) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := a.refresh(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("background aggregation failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSitemaps serves an index of the given sitemaps, each a handler
+func fakeSitemaps(t *testing.T, sitemaps map[string]http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	var index strings.Builder
+	index.WriteString("<sitemapindex>")
+	for name, h := range sitemaps {
+		fmt.Fprintf(&index, "<sitemap><loc>%s/%s</loc></sitemap>", srv.URL, name)
+		mux.HandleFunc("/"+name, h)
+	}
+	index.WriteString("</sitemapindex>")
+	mux.HandleFunc("/index.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(index.String()))
+	})
+
+	old := *sitemapIndexURL
+	*sitemapIndexURL = srv.URL + "/index.xml"
+	t.Cleanup(func() { *sitemapIndexURL = old })
+	return srv
+}
+
+// sitemap serves a news sitemap with the given titles
+func sitemap(titles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		b.WriteString(`<urlset xmlns:news="http://www.google.com/schemas/sitemap-news/0.9">`)
+		for _, title := range titles {
+			fmt.Fprintf(&b, "<url><loc>https://news.example.com/%s</loc><news:news><news:title>%s</news:title></news:news></url>",
+				strings.ReplaceAll(title, " ", "-"), title)
+		}
+		b.WriteString("</urlset>")
+		w.Write([]byte(b.String()))
+	}
+}
+
+func TestRefreshCancelled(t *testing.T) {
+	fakeSitemaps(t, map[string]http.HandlerFunc{
+		"a.xml": sitemap("Storm hits the coast"),
+		// Hangs until the refresh gives up
+		"b.xml": func(w http.ResponseWriter, r *http.Request) { <-r.Context().Done() },
+	})
+	a := &aggregator{sources: make(map[string]sourceStatus)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := a.refresh(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("refresh returned %v, want the context's error", err)
+	}
+	if a.snapshot() != nil {
+		t.Error("a cancelled refresh stored its partial result")
+	}
+}
+
+func TestRefreshHungSource(t *testing.T) {
+	defer func(old time.Duration) { *fetchTimeout = old }(*fetchTimeout)
+	*fetchTimeout = 100 * time.Millisecond
+	hung := make(chan struct{})
+	fakeSitemaps(t, map[string]http.HandlerFunc{
+		"a.xml": sitemap("Storm hits the coast"),
+		"b.xml": func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-hung:
+			}
+		},
+	})
+	// Let b go before the server closes, it waits on its handlers
+	t.Cleanup(func() { close(hung) })
+	a := &aggregator{sources: make(map[string]sourceStatus)}
+
+	// Nothing cancels this one, only --fetch-timeout gets it past b
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.refresh(context.Background())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh hung on a sitemap that never answers")
+	}
+	if _, ok := a.snapshot()["Storm hits the coast"]; !ok {
+		t.Error("lost a's article waiting on b")
+	}
+}
+
+func TestRefreshKeepsFailedSource(t *testing.T) {
+	var bDown atomic.Bool
+	fakeSitemaps(t, map[string]http.HandlerFunc{
+		"a.xml": sitemap("Storm hits the coast"),
+		"b.xml": func(w http.ResponseWriter, r *http.Request) {
+			if bDown.Load() {
+				http.Error(w, "down", http.StatusBadGateway)
+				return
+			}
+			sitemap("Markets rally after rate cut")(w, r)
+		},
+	})
+	a := &aggregator{sources: make(map[string]sourceStatus)}
+	sub := broker.subscribe(10, dropNewest)
+	defer broker.unsubscribe(sub)
+
+	ctx := context.Background()
+	for i, down := range []bool{false, true, false} {
+		bDown.Store(down)
+		newsMap, err := a.refresh(ctx)
+		if err != nil {
+			t.Fatalf("refresh %d: %v", i, err)
+		}
+		if _, ok := newsMap["Markets rally after rate cut"]; !ok {
+			t.Errorf("refresh %d, b down %v: lost b's article", i, down)
+		}
+	}
+	select {
+	case ev := <-sub.C:
+		t.Errorf("%q published as new after its sitemap came back", ev.Title)
+	default:
+	}
+}
+
+func TestHistoryKeepsPermalinks(t *testing.T) {
+	defer func(old int) { *historySize = old }(*historySize)
+	*historySize = 2
+
+	article := func(title string) NewsMap {
+		loc := "https://news.example.com/" + strings.ReplaceAll(title, " ", "-")
+		return NewsMap{Location: loc, ID: articleID(loc)}
+	}
+	aggregation := func(titles ...string) map[string]NewsMap {
+		newsMap := make(map[string]NewsMap)
+		for _, title := range titles {
+			newsMap[title] = article(title)
+		}
+		return newsMap
+	}
+
+	a := &aggregator{}
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	steps := [][]string{
+		{"two", "four"},
+		{"one", "two", "three", "four"},
+		{"four"},          // one, two and three drop out, two was first seen first so it goes
+		{"four", "three"}, // three is back
+		{"four", "three", "five"},
+	}
+	var added []NewsEvent
+	for i, titles := range steps {
+		added = a.store(aggregation(titles...), start.Add(time.Duration(i)*time.Hour))
+		if i == 2 {
+			want := []string{article("one").ID, article("three").ID}
+			if !slices.Equal(a.historyOrder, want) {
+				t.Errorf("history order %v, want one then three %v", a.historyOrder, want)
+			}
+		}
+	}
+
+	if len(added) != 1 || added[0].Title != "five" {
+		t.Errorf("last refresh added %v, want only five", added)
+	}
+	if _, _, _, ok := a.article(article("two").ID); ok {
+		t.Error("two is still held past --history")
+	}
+	title, _, dropped, ok := a.article(article("one").ID)
+	if !ok || title != "one" || !dropped.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("one: %q %v %v, want it dropped at the third refresh", title, dropped, ok)
+	}
+	title, data, dropped, ok := a.article(article("three").ID)
+	if !ok || title != "three" || !dropped.IsZero() || !data.FirstSeen.Equal(start.Add(time.Hour)) {
+		t.Errorf("three: %q %v %v, want it current and first seen at the second refresh", title, dropped, ok)
+	}
+	if len(a.history) != 1 || len(a.historyOrder) != 1 {
+		t.Errorf("history holds %d, order %d, want just one", len(a.history), len(a.historyOrder))
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+var (
+	alertRulesFile = flag.String("alert-rules", "", "JSON file of keyword alert rules posted to webhooks, alerts are off when empty")
+	alertStateFile = flag.String("alert-state", "", "file remembering which articles already alerted, so restarts don't alert again")
+	alertQueueSize = flag.Int("alert-queue", 256, "alerts waiting to be delivered before new ones wait for the next refresh")
+)
+
+// Delivery tuning, alerts remembered for this long are forgotten
+const (
+	alertAttempts    = 4
+	alertBackoff     = time.Second
+	alertRememberFor = 30 * 24 * time.Hour
+)
+
+var (
+	alertsSent = web.NewCounter("newsagg_alerts_sent_total",
+		"Webhook alerts delivered, by rule.", "rule")
+	alertsFailed = web.NewCounter("newsagg_alerts_failed_total",
+		"Webhook alerts that failed after every retry or were refused, by rule.", "rule")
+)
+
+// errAlertRefused is a webhook answering with a status that retrying won't change
+var errAlertRefused = errors.New("webhook refused the alert")
+
+// alertRule is one entry of the --alert-rules file, e.g.
+//
+//	{"name": "storms", "keywords": ["hurricane"], "pattern": "(?i)storm",
+//	 "webhook": "https://example.com/hook", "secret": "s3cret"}
+//
+// Every condition given must match, within keywords or sources any one will do
+type alertRule struct {
+	Name     string   `json:"name"`
+	Keywords []string `json:"keywords"` // exact article keyword, any case
+	Pattern  string   `json:"pattern"`  // regexp over the title
+	Sources  []string `json:"sources"`  // part of the sitemap URL
+	Webhook  string   `json:"webhook"`
+	Secret   string   `json:"secret"` // signs the payload when set
+
+	keywords map[string]bool
+	pattern  *regexp.Regexp
+	sources  []string
+}
+
+func (rule *alertRule) compile() error {
+	// A null in the rules list unmarshals to a nil rule
+	if rule == nil {
+		return errors.New("null rule")
+	}
+	if rule.Name == "" {
+		return errors.New("rule without a name")
+	}
+	u, err := url.Parse(rule.Webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("rule %s: webhook must be an http(s) URL", rule.Name)
+	}
+	if len(rule.Keywords) == 0 && rule.Pattern == "" && len(rule.Sources) == 0 {
+		return fmt.Errorf("rule %s: needs keywords, a pattern or sources", rule.Name)
+	}
+	if rule.Pattern != "" {
+		if rule.pattern, err = regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+	}
+	rule.keywords = make(map[string]bool)
+	for _, k := range normalizeTerms(rule.Keywords) {
+		rule.keywords[k] = true
+	}
+	rule.sources = normalizeTerms(rule.Sources)
+	return nil
+}
+
+func (rule *alertRule) matches(title string, data NewsMap) bool {
+	if rule.pattern != nil && !rule.pattern.MatchString(title) {
+		return false
+	}
+	if len(rule.sources) > 0 {
+		found := false
+		for _, s := range rule.sources {
+			if strings.Contains(strings.ToLower(data.Source), s) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(rule.keywords) == 0 {
+		return true
+	}
+	for _, k := range splitKeywords(data.Keyword) {
+		if rule.keywords[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// alertPayload is the JSON body posted to the webhook
+type alertPayload struct {
+	Rule    string    `json:"rule"`
+	Article NewsEvent `json:"article"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+type alertDelivery struct {
+	key     string
+	rule    *alertRule
+	payload alertPayload
+}
+
+// alerter checks every aggregation against the rules and posts matches
+// Each rule and article pair is only ever alerted once
+type alerter struct {
+	rules     []*alertRule
+	client    *http.Client
+	statePath string
+	queues    map[string]chan alertDelivery // one per webhook URL
+
+	// Both keyed by rule and article ID. done has the alerts delivered or
+	// refused for good, only those are saved so an alert still queued when
+	// the server stops is sent after the restart
+	mu     sync.Mutex
+	queued map[string]bool
+	done   map[string]time.Time
+
+	saveMu sync.Mutex // keeps state saves in order
+}
+
+// Set in main when --alert-rules is given
+var alerts *alerter
+
+func loadAlerter(rulesPath, statePath string) (*alerter, error) {
+	data, err := os.ReadFile(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*alertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", rulesPath, err)
+	}
+	al := &alerter{
+		rules:     rules,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		statePath: statePath,
+		queues:    make(map[string]chan alertDelivery),
+		queued:    make(map[string]bool),
+		done:      make(map[string]time.Time),
+	}
+	// Names key the state file, two rules sharing one would only alert once between them
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("%s: %w", rulesPath, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("%s: rule %s: name used twice", rulesPath, rule.Name)
+		}
+		names[rule.Name] = true
+		if al.queues[rule.Webhook] == nil {
+			al.queues[rule.Webhook] = make(chan alertDelivery, *alertQueueSize)
+		}
+	}
+	if statePath != "" {
+		if data, err := os.ReadFile(statePath); err == nil {
+			if err := json.Unmarshal(data, &al.done); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", statePath, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return al, nil
+}
+
+// evaluate queues an alert for every rule an article matches for the first time
+func (al *alerter) evaluate(newsMap map[string]NewsMap) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	for title, data := range newsMap {
+		for _, rule := range al.rules {
+			key := rule.Name + "|" + data.ID
+			if _, done := al.done[key]; done || al.queued[key] || !rule.matches(title, data) {
+				continue
+			}
+			delivery := alertDelivery{key: key, rule: rule, payload: alertPayload{Rule: rule.Name, Article: newNewsEvent(title, data)}}
+			select {
+			case al.queues[rule.Webhook] <- delivery:
+				// Marked straight away so the next refresh doesn't queue it again
+				al.queued[key] = true
+			default:
+				slog.Warn("alert queue full, will retry on the next refresh", "rule", rule.Name, "article", data.ID)
+			}
+		}
+	}
+}
+
+// run delivers queued alerts until ctx is done, each webhook on its own so
+// a slow or dead one only holds up its own alerts
+func (al *alerter) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, queue := range al.queues {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			al.drain(ctx, queue)
+		}()
+	}
+	wg.Wait()
+}
+
+// drain delivers one webhook's alerts one at a time
+func (al *alerter) drain(ctx context.Context, queue <-chan alertDelivery) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-queue:
+			err := al.deliver(ctx, d)
+			refused := errors.Is(err, errAlertRefused)
+			al.mu.Lock()
+			// Failed ones are forgotten so the next refresh tries again,
+			// refused ones would only be refused again
+			delete(al.queued, d.key)
+			if err == nil || refused {
+				al.done[d.key] = time.Now()
+			}
+			al.mu.Unlock()
+
+			switch {
+			case refused:
+				alertsFailed.Inc(d.rule.Name)
+				slog.Error("alert refused, not sending it again", "rule", d.rule.Name, "article", d.payload.Article.ID, "err", err)
+			case err != nil:
+				alertsFailed.Inc(d.rule.Name)
+				slog.Error("alert delivery failed", "rule", d.rule.Name, "article", d.payload.Article.ID, "err", err)
+				continue
+			default:
+				alertsSent.Inc(d.rule.Name)
+				slog.Info("alert sent", "rule", d.rule.Name, "article", d.payload.Article.ID)
+			}
+			if err := al.saveState(); err != nil {
+				slog.Error("saving alert state failed", "path", al.statePath, "err", err)
+			}
+		}
+	}
+}
+
+// deliver posts the alert, retrying with backoff on network errors, 408s, 429s and 5xxs
+func (al *alerter) deliver(ctx context.Context, d alertDelivery) error {
+	d.payload.SentAt = time.Now().UTC()
+	body, err := json.Marshal(d.payload)
+	if err != nil {
+		return err
+	}
+
+	backoff := alertBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := al.post(ctx, d, body)
+		if err == nil || !retry || attempt == alertAttempts {
+			return err
+		}
+		slog.Warn("alert delivery failed, retrying", "rule", d.rule.Name, "attempt", attempt, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends one attempt, returns whether a failure is worth retrying
+func (al *alerter) post(ctx context.Context, d alertDelivery, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.rule.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Newsagg-Delivery", d.key)
+	if d.rule.Secret != "" {
+		req.Header.Set("X-Newsagg-Signature-256", "sha256="+signPayload(d.rule.Secret, body))
+	}
+
+	resp, err := al.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook answered %s", resp.Status)
+	default:
+		return false, fmt.Errorf("%w: %s", errAlertRefused, resp.Status)
+	}
+}
+
+// signPayload is the hex HMAC-SHA256 of the body, receivers recompute it
+// with the shared secret to check the alert came from us
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// saveState writes the finished alerts to --alert-state, dropping old ones
+func (al *alerter) saveState() error {
+	if al.statePath == "" {
+		return nil
+	}
+	// Webhooks deliver in parallel, an older snapshot mustn't be written last
+	al.saveMu.Lock()
+	defer al.saveMu.Unlock()
+	al.mu.Lock()
+	for key, at := range al.done {
+		if time.Since(at) > alertRememberFor {
+			delete(al.done, key)
+		}
+	}
+	data, err := json.MarshalIndent(al.done, "", "  ")
+	al.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(al.statePath, data)
+}
+
+// writeFileAtomic writes then renames so a crash can't leave a half written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
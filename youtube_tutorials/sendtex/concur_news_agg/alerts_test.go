@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeAlertRules(t *testing.T, rules string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readAlertState(t *testing.T, path string) map[string]time.Time {
+	t.Helper()
+	state := map[string]time.Time{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestAlertStateOnlyHasDeliveries(t *testing.T) {
+	hits := make(chan string, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits <- r.Header.Get("X-Newsagg-Delivery")
+	}))
+	defer hook.Close()
+
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	rules := writeAlertRules(t, `[{"name": "storms", "keywords": ["weather"], "webhook": "`+hook.URL+`"}]`)
+	al, err := loadAlerter(rules, statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	al.evaluate(map[string]NewsMap{"Storm hits the coast": {ID: "a1", Keyword: "weather"}})
+	// Queued but not sent, a restart now has to send it
+	if err := al.saveState(); err != nil {
+		t.Fatal(err)
+	}
+	if state := readAlertState(t, statePath); len(state) != 0 {
+		t.Fatalf("queued alert saved as delivered: %v", state)
+	}
+	// and it isn't queued twice meanwhile
+	al.evaluate(map[string]NewsMap{"Storm hits the coast": {ID: "a1", Keyword: "weather"}})
+	if len(al.queues[hook.URL]) != 1 {
+		t.Fatalf("%d alerts queued, want 1", len(al.queues[hook.URL]))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go al.run(ctx)
+	if key := <-hits; key != "storms|a1" {
+		t.Fatalf("delivered %q", key)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(readAlertState(t, statePath)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := readAlertState(t, statePath)["storms|a1"]; !ok {
+		t.Error("delivered alert not saved")
+	}
+}
+
+func TestAlertRefusedNotRetried(t *testing.T) {
+	hits := make(chan string, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits <- r.Header.Get("X-Newsagg-Delivery")
+		http.Error(w, "no such hook", http.StatusNotFound)
+	}))
+	defer hook.Close()
+
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	al, err := loadAlerter(writeAlertRules(t, `[{"name": "storms", "keywords": ["weather"], "webhook": "`+hook.URL+`"}]`), statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go al.run(ctx)
+
+	newsMap := map[string]NewsMap{"Storm hits the coast": {ID: "a1", Keyword: "weather"}}
+	al.evaluate(newsMap)
+	<-hits
+	deadline := time.Now().Add(2 * time.Second)
+	for len(readAlertState(t, statePath)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := readAlertState(t, statePath)["storms|a1"]; !ok {
+		t.Fatal("refused alert not saved as done")
+	}
+
+	// Later refreshes leave it be
+	al.evaluate(newsMap)
+	select {
+	case key := <-hits:
+		t.Errorf("refused alert %s sent again", key)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAlertWebhooksDeliverSeparately(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	fastHits := make(chan string, 10)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fastHits <- r.Header.Get("X-Newsagg-Delivery")
+	}))
+	defer fast.Close()
+
+	al, err := loadAlerter(writeAlertRules(t, `[
+		{"name": "slow", "keywords": ["weather"], "webhook": "`+slow.URL+`"},
+		{"name": "fast", "keywords": ["weather"], "webhook": "`+fast.URL+`"}]`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go al.run(ctx)
+
+	al.evaluate(map[string]NewsMap{"Storm hits the coast": {ID: "a1", Keyword: "weather"}})
+	select {
+	case key := <-fastHits:
+		if key != "fast|a1" {
+			t.Errorf("delivered %q", key)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("fast webhook waited on the slow one")
+	}
+}
+
+func TestBadAlertRules(t *testing.T) {
+	for _, rules := range []string{
+		`[null]`,
+		`[{"name": "storms", "keywords": ["weather"], "webhook": "https://example.com/hook"}, null]`,
+		`[{"keywords": ["weather"], "webhook": "https://example.com/hook"}]`,
+		`[{"name": "storms", "keywords": ["weather"], "webhook": "ftp://example.com/hook"}]`,
+		`[{"name": "storms", "webhook": "https://example.com/hook"}]`,
+		`[{"name": "storms", "pattern": "(", "webhook": "https://example.com/hook"}]`,
+		`[{"name": "storms", "keywords": ["weather"], "webhook": "https://example.com/a"},
+		  {"name": "storms", "pattern": "(?i)storm", "webhook": "https://example.com/b"}]`,
+	} {
+		if _, err := loadAlerter(writeAlertRules(t, rules), ""); err == nil {
+			t.Errorf("%s: loaded", rules)
+		}
+	}
+}
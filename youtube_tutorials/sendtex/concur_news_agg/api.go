@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+// newsAPIArticle is an article with any near duplicates from other outlets
+type newsAPIArticle struct {
+	NewsEvent
+	Duplicates []NewsEvent `json:"duplicates,omitempty"`
+}
+
+// newsAPIPage is the body of /api/news
+type newsAPIPage struct {
+	Total  int `json:"total"`
+	Hidden int `json:"hidden"` // left out by the caller's preferences, ?all=1 includes them
+	Page   int `json:"page"`
+	Pages  int `json:"pages"`
+	// Articles in each language before the lang filter, to build a picker from
+	Languages map[string]int   `json:"languages"`
+	Articles  []newsAPIArticle `json:"articles"`
+}
+
+// newsAPIHandler is /agg/ as JSON, it takes the same q, sort, order, lang, page and per parameters
+func newsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	newsMap, err := agg.news(r.Context())
+	if err != nil {
+		slog.Error("aggregation failed", "err", err, "request_id", web.RequestIDFrom(r.Context()))
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "could not fetch the news sitemaps"})
+		return
+	}
+	newsMap, hidden, modified := userNews(r, newsMap, r.URL.Query().Get("all") == "1")
+	w.Header().Add("Vary", "Cookie")
+	// Private, the response may have needed an API key
+	if notModified(w, r, modified, "private, no-cache") {
+		return
+	}
+
+	q := parseTableQuery(r.URL.Query())
+	rows, total, pages := buildRows(newsMap, &q, agg.clusterKeys())
+	p := newsAPIPage{Total: total, Hidden: hidden, Page: q.Page, Pages: pages, Languages: languageCounts(newsMap), Articles: make([]newsAPIArticle, 0, len(rows))}
+	event := func(row NewsRow) NewsEvent {
+		ev := newNewsEvent(row.Title, newsMap[row.Title])
+		ev.Summary = agg.summary(ev.ID)
+		return ev
+	}
+	for _, row := range rows {
+		a := newsAPIArticle{NewsEvent: event(row)}
+		for _, other := range row.Others {
+			a.Duplicates = append(a.Duplicates, event(other))
+		}
+		p.Articles = append(p.Articles, a)
+	}
+	writeJSON(w, http.StatusOK, p)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How many related articles the detail page lists
+const maxRelated = 5
+
+// articleID is derived from the article URL so permalinks survive restarts
+func articleID(location string) string {
+	sum := sha256.Sum256([]byte(location))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Sitemaps use full timestamps or just a date
+var publishedLayouts = []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02"}
+
+func parsePublished(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range publishedLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// splitKeywords turns "Politics, US news" into ["politics", "us news"]
+func splitKeywords(keyword string) []string {
+	var out []string
+	for _, k := range strings.Split(keyword, ",") {
+		if k = strings.ToLower(strings.TrimSpace(k)); k != "" {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// RelatedArticle is a link to another article sharing keywords
+type RelatedArticle struct {
+	Title  string
+	ID     string
+	Shared int // number of keywords in common
+}
+
+// relatedArticles ranks the other articles by how many keywords they share with this one
+func relatedArticles(newsMap map[string]NewsMap, title string, limit int) []RelatedArticle {
+	want := make(map[string]bool)
+	for _, k := range splitKeywords(newsMap[title].Keyword) {
+		want[k] = true
+	}
+
+	var related []RelatedArticle
+	for other, data := range newsMap {
+		if other == title {
+			continue
+		}
+		shared := 0
+		for _, k := range splitKeywords(data.Keyword) {
+			if want[k] {
+				shared++
+			}
+		}
+		if shared > 0 {
+			related = append(related, RelatedArticle{Title: other, ID: data.ID, Shared: shared})
+		}
+	}
+
+	sort.Slice(related, func(i, j int) bool {
+		if related[i].Shared != related[j].Shared {
+			return related[i].Shared > related[j].Shared
+		}
+		return related[i].Title < related[j].Title
+	})
+	return related[:min(len(related), limit)]
+}
+
+// NewsArticlePage ...
+type NewsArticlePage struct {
+	Title    string
+	Article  NewsMap
+	Keywords []string
+	Summary  string
+	Related  []RelatedArticle
+	Dropped  time.Time // when it left the sitemaps, zero if it's still in them
+}
+
+// LanguageName is the article's language as a reader would know it
+func (p NewsArticlePage) LanguageName() string {
+	return languageName(p.Article.Language)
+}
+
+// newsArticleHandler shows one article of the last aggregation at /news/{id},
+// or of the history so links to it keep working after it leaves the sitemaps
+func newsArticleHandler(w http.ResponseWriter, r *http.Request) {
+	title, data, dropped, ok := agg.article(r.PathValue("id"))
+	if !ok {
+		pages.RenderError(w, r, http.StatusNotFound)
+		return
+	}
+	pages.Render(w, "news.html", NewsArticlePage{
+		Title:    title,
+		Article:  data,
+		Keywords: splitKeywords(data.Keyword),
+		Summary:  agg.summary(data.ID),
+		Related:  relatedArticles(agg.snapshot(), title, maxRelated),
+		Dropped:  dropped,
+	})
+}
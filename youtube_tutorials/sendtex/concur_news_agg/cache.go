@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Pages built from the aggregation only change when a refresh changes it,
+// so the validators come from that time. The start time is mixed into the
+// ETag so a restart with new templates doesn't match old copies.
+
+// newsETag is a weak ETag, the gzipped and plain bodies share it
+func newsETag(modified time.Time) string {
+	return fmt.Sprintf(`W/"%x-%x"`, startedAt.Unix(), modified.UnixNano())
+}
+
+// notModified sets ETag, Last-Modified and Cache-Control, then answers 304
+// if the client's copy is still current
+// cacheControl is "no-cache" so clients keep a copy but check it every time
+func notModified(w http.ResponseWriter, r *http.Request, modified time.Time, cacheControl string) bool {
+	h := w.Header()
+	h.Set("Cache-Control", cacheControl)
+	// Templates reload under --dev, the page can change without a refresh
+	if modified.IsZero() || *devMode {
+		return false
+	}
+	etag := newsETag(modified)
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+	// If-None-Match wins over If-Modified-Since when both are sent
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil ||
+		modified.Truncate(time.Second).After(ims) {
+		return false
+	}
+
+	// 304s carry no body, so drop the body headers
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// Weak comparison, as If-None-Match is meant to be used
+func etagMatches(header, etag string) bool {
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
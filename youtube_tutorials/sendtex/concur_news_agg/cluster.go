@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"sort"
+	"strings"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+var clusterThreshold = flag.Float64("cluster-threshold", 0.5, "title and keyword similarity, 0 to 1, at which articles are shown as one story, 0 turns grouping off")
+
+var storyClusters = web.NewGauge("newsagg_story_clusters",
+	"Stories covered by more than one article in the most recent refresh.")
+
+// Keywords count for less than title words, sections like "politics" are
+// shared by lots of articles about different things
+const keywordWeight = 0.5
+
+// Terms in more than this share of articles say nothing about which story
+// an article is, and would make every article a candidate for every other
+const maxTermShare = 0.2
+
+// clusterArticles groups articles telling the same story by the cosine
+// similarity of their TF-IDF weighted title words and keywords, joining any
+// two at or above threshold. Only articles sharing a term are compared.
+// Returns each clustered article's cluster key, the smallest title in its
+// cluster. Articles without a near duplicate aren't in the map.
+func clusterArticles(newsMap map[string]NewsMap, threshold float64) map[string]string {
+	if threshold <= 0 || len(newsMap) < 2 {
+		return nil
+	}
+
+	titles := make([]string, 0, len(newsMap))
+	for title := range newsMap {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	// Term counts per article, and how many articles have each term
+	counts := make([]map[string]float64, len(titles))
+	df := map[string]int{}
+	for i, title := range titles {
+		c := map[string]float64{}
+		for _, w := range contentWords(title) {
+			c[stem(w)]++
+		}
+		for _, k := range splitKeywords(newsMap[title].Keyword) {
+			c["kw:"+k] += keywordWeight
+		}
+		counts[i] = c
+		for term := range c {
+			df[term]++
+		}
+	}
+
+	// Normalised TF-IDF vectors and an index from term to the articles with it
+	n := float64(len(titles))
+	maxDF := max(2, int(maxTermShare*n))
+	vectors := make([]map[string]float64, len(titles))
+	postings := map[string][]int{}
+	for i, c := range counts {
+		v := map[string]float64{}
+		norm := 0.0
+		for term, tf := range c {
+			if df[term] > maxDF {
+				continue
+			}
+			w := tf * math.Log(n/float64(df[term]))
+			if w <= 0 {
+				continue
+			}
+			v[term] = w
+			norm += w * w
+		}
+		norm = math.Sqrt(norm)
+		for term := range v {
+			v[term] /= norm
+			if df[term] > 1 {
+				postings[term] = append(postings[term], i)
+			}
+		}
+		vectors[i] = v
+	}
+
+	parent := make([]int, len(titles))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i, v := range vectors {
+		// Dot products with every later article sharing a term
+		dots := map[int]float64{}
+		for term, w := range v {
+			for _, j := range postings[term] {
+				if j > i {
+					dots[j] += w * vectors[j][term]
+				}
+			}
+		}
+		for j, sim := range dots {
+			if sim >= threshold {
+				// Lower index is the root, so the key is the smallest title
+				a, b := find(i), find(j)
+				parent[max(a, b)] = min(a, b)
+			}
+		}
+	}
+
+	keys := map[string]string{}
+	size := map[int]int{}
+	for i := range titles {
+		size[find(i)]++
+	}
+	clusters := 0
+	for i, title := range titles {
+		root := find(i)
+		if size[root] < 2 {
+			continue
+		}
+		if root == i {
+			clusters++
+		}
+		keys[title] = titles[root]
+	}
+	storyClusters.Set(float64(clusters))
+	return keys
+}
+
+// stem folds simple plurals so "storms" and "storm" match
+func stem(w string) string {
+	if len(w) > 4 && strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") {
+		return w[:len(w)-1]
+	}
+	return w
+}
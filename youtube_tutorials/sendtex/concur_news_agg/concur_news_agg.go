@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
-	"html/template"
-	"io/ioutil"
+	"io"
+	"log/slog"
 	"net/http"
-	"strings"
-	"sync"
+	"time"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
 )
 
 // Vid 17
@@ -19,99 +23,236 @@ type SitemapIndex struct {
 }
 
 // News ...
+// One entry per <url> so optional fields can't shift the others out of line
 type News struct {
-	Titles    []string `xml:"url>news>title"`
-	Keywords  []string `xml:"url>news>keywords"`
-	Locations []string `xml:"url>loc"`
+	URLs   []NewsURL `xml:"url"`
+	Source string    `xml:"-"` // sitemap the news was read from
+}
+
+// NewsURL is one article in a news sitemap
+type NewsURL struct {
+	Location        string `xml:"loc"`
+	Title           string `xml:"news>title"`
+	Keywords        string `xml:"news>keywords"`
+	PublicationDate string `xml:"news>publication_date"`
+	Language        string `xml:"news>publication>language"`
 }
 
 // NewsMap Key of the Map is the title ...
 type NewsMap struct {
-	Keyword  string
-	Location string
+	Keyword   string
+	Location  string
+	ID        string // stable ID used in the /news/{id} permalink
+	Source    string
+	Published time.Time // zero if the sitemap didn't say
+	Language  string    // declared by the sitemap, else detected, "und" if neither
+	FirstSeen time.Time // when this server first aggregated it
 }
 
 // NewsAggPage ...
 type NewsAggPage struct {
-	Title string
-	News  map[string]NewsMap
+	Title     string
+	Rows      []NewsRow
+	Query     tableQuery
+	Total     int
+	Pages     int
+	Hidden    int              // articles left out by the user's preferences
+	Saved     []savedSearch    // the user's saved searches
+	Languages []languageOption // languages of the articles, for the picker
 }
 
-var newsAggWaitGroup sync.WaitGroup
+var (
+	sitemapIndexURL = flag.String("sitemap-index", "https://www.washingtonpost.com/news-sitemaps/index.xml", "news sitemap index to aggregate")
+	pageTitle       = flag.String("title", "Amazing News Agg Page", "title of the /agg/ page")
+	fetchTimeout    = flag.Duration("fetch-timeout", 30*time.Second, "max time to fetch and parse one sitemap")
+)
 
-// Go routine to pull the news objects
-func newsRoutine(channelObj chan News, Location string) {
-	defer newsAggWaitGroup.Done()
-	var newsObj News
-	resp, _ := http.Get(strings.TrimSpace(Location))
-	bytes, _ := ioutil.ReadAll(resp.Body)
-	// Create a news Obj from response Data
-	xml.Unmarshal(bytes, &newsObj)
-	resp.Body.Close()
+// Fetch pipeline metrics, sources are labelled by sitemap URL
+var (
+	sitemapFetchDuration = web.NewHistogram("newsagg_sitemap_fetch_duration_seconds",
+		"Time taken to fetch and parse a sitemap, by source.", web.DefaultBuckets, "source")
+	sitemapFetchErrors = web.NewCounter("newsagg_sitemap_fetch_errors_total",
+		"Sitemap fetches that failed, by source.", "source")
+	sitemapFetchShared = web.NewCounter("newsagg_sitemap_fetch_shared_total",
+		"Sitemap fetches skipped by joining one already in flight, by source.", "source")
+	refreshesTotal = web.NewCounter("newsagg_refreshes_total",
+		"Aggregation refreshes run.")
+	refreshArticles = web.NewGauge("newsagg_refresh_articles",
+		"Articles aggregated by the most recent refresh.")
+	fetchWorkers = web.NewGauge("newsagg_fetch_workers_active",
+		"Sitemap fetch goroutines currently running.")
+)
 
-	// Fill the News Objects into the Channel
-	channelObj <- newsObj
+// fetchXML gets a sitemap and decodes it into v, recording how long it took
+// A hung source gives up after --fetch-timeout instead of holding up the
+// refresh and every request sharing its fetch
+func fetchXML(ctx context.Context, location string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, *fetchTimeout)
+	defer cancel()
+	start := time.Now()
+	err := getXML(ctx, location, v)
+	sitemapFetchDuration.Observe(time.Since(start).Seconds(), location)
+	if err != nil {
+		sitemapFetchErrors.Inc(location)
+	}
+	return err
 }
 
-func newsAggHandler(w http.ResponseWriter, r *http.Request) {
+func getXML(ctx context.Context, location string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", location, resp.Status)
+	}
+	bytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", location, err)
+	}
+	if err := xml.Unmarshal(bytes, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", location, err)
+	}
+	return nil
+}
 
-	// Parse XML
-	var siteMapIndexObj SitemapIndex
+func newsAggHandler(w http.ResponseWriter, r *http.Request) {
 
-	resp, _ := http.Get("https://www.washingtonpost.com/news-sitemaps/index.xml")
-	bytes, _ := ioutil.ReadAll(resp.Body)
-	xml.Unmarshal(bytes, &siteMapIndexObj)
-	resp.Body.Close()
+	newsMap, err := agg.news(r.Context())
+	if err != nil {
+		slog.Error("aggregation failed", "err", err, "request_id", web.RequestIDFrom(r.Context()))
+		http.Error(w, "Could not fetch the news sitemaps", http.StatusBadGateway)
+		return
+	}
+	// Muted keywords and hidden sources are left out unless ?all=1
+	newsMap, hidden, modified := userNews(r, newsMap, r.URL.Query().Get("all") == "1")
+	w.Header().Add("Vary", "Cookie")
+	if notModified(w, r, modified, "private, no-cache") {
+		return
+	}
 
-	newsMap := make(map[string]NewsMap)
+	// NewsMap contains all the data we want
+	// Only logged at debug level, this is one line per article
+	for title, data := range newsMap {
+		slog.Debug("article", "title", title, "keywords", data.Keyword, "location", data.Location)
+	}
+	// Build the page, sorting and paging is done here instead of in the browser
+	p := NewsAggPage{
+		Title:  *pageTitle,
+		Query:  parseTableQuery(r.URL.Query()),
+		Hidden: hidden,
+		Saved:  prefs.get(profileID(r)).SavedSearches,
+	}
+	p.Languages = p.languageOptions(newsMap)
+	p.Rows, p.Total, p.Pages = buildRows(newsMap, &p.Query, agg.clusterKeys())
+	for i := range p.Rows {
+		p.Rows[i].Summary = agg.summary(p.Rows[i].ID)
+	}
+	// Execute the page
+	pages.Render(w, "newsaggtemplate.html", p)
 
-	// Channel to push News Objects into
-	queue := make(chan News, 30)
+}
 
-	// Call the Go Routines to concurrently pull Info from each XML
-	for _, Location := range siteMapIndexObj.Locations {
-		newsAggWaitGroup.Add(1)
-		go newsRoutine(queue, Location)
+// checkNewsConfig validates the aggregator's own settings
+func checkNewsConfig() error {
+	var errs []error
+	if err := web.CheckURL("sitemap-index", *sitemapIndexURL); err != nil {
+		errs = append(errs, err)
+	}
+	if *pageTitle == "" {
+		errs = append(errs, errors.New("--title can't be empty"))
+	}
+	if *refreshInterval < 0 || *maxAge < 0 {
+		errs = append(errs, errors.New("--refresh-interval and --max-age can't be negative"))
+	}
+	if *historySize < 0 {
+		errs = append(errs, errors.New("--history can't be negative"))
+	}
+	if *staleAfter <= 0 || *fetchTimeout <= 0 {
+		errs = append(errs, errors.New("--stale-after and --fetch-timeout must be more than 0"))
+	}
+	if *eventBuffer < 1 || *wsBuffer < 1 || *alertQueueSize < 1 {
+		errs = append(errs, errors.New("--event-buffer, --ws-buffer and --alert-queue must be at least 1"))
+	}
+	if *clusterThreshold < 0 || *clusterThreshold > 1 {
+		errs = append(errs, errors.New("--cluster-threshold must be between 0 and 1"))
 	}
+	if *enrichWorkers < 1 || *summarySentences < 1 || *enrichTimeout <= 0 {
+		errs = append(errs, errors.New("--enrich-workers, --summary-sentences and --enrich-timeout must be more than 0"))
+	}
+	return errors.Join(errs...)
+}
 
-	// Wait for channel Buffer to fill, then close it
-	newsAggWaitGroup.Wait()
-	close(queue)
+func main() {
+	// A config file and NEWSAGG_* env vars fill in any flags not given
+	web.Setup("NEWSAGG_", checkNewsConfig)
 
-	// Iterate the Channel to get news Type Objects
-	for elem := range queue {
-		// Each News Object has a number of Keywords
-		for idx := range elem.Keywords {
-			newsMap[elem.Titles[idx]] = NewsMap{elem.Keywords[idx], elem.Locations[idx]}
+	// Writes a file from one aggregation instead of serving
+	if flag.Arg(0) == "export" {
+		if err := runExport(flag.Args()[1:]); err != nil {
+			web.Fatal("export failed", err)
 		}
+		return
 	}
 
-	// NewsMap contains all the data we want
-	for title, data := range newsMap {
-		fmt.Println("\n\n\nTitle: ", title)
-		fmt.Println("\nKeywords: ", data.Keyword)
-		fmt.Println("\nLocation: ", data.Location)
+	// Parse the templates once, not on every request
+	var err error
+	pages, err = loadTemplates()
+	if err != nil {
+		web.Fatal("loading templates failed", err)
 	}
-	// Build the page
-	p := NewsAggPage{Title: "Amazing News Agg Page", News: newsMap}
-	t, err := template.ParseFiles("newsaggtemplate.html")
 
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	// Routes match on method and path, anything else gets a 404 or 405 page
+	mux := web.NewRouter(pages)
+
+	// Vid 17
+	mux.HandleFunc("GET /agg/{$}", newsAggHandler)
+	mux.HandleFunc("GET /news/{id}", newsArticleHandler)
+	mux.HandleFunc("GET /api/news", newsAPIHandler)
+	mux.HandleFunc("GET /export/news.csv", exportHandler("csv"))
+	mux.HandleFunc("GET /export/news.jsonl", exportHandler("jsonl"))
+	mux.HandleFunc("GET /export/news.md", exportHandler("md"))
+	mux.HandleFunc("GET /prefs", prefsHandler)
+	mux.HandleFunc("POST /prefs", savePrefsHandler)
+	mux.HandleFunc("GET /api/prefs", prefsAPIHandler)
+	mux.HandleFunc("PUT /api/prefs", putPrefsAPIHandler)
+	mux.HandleFunc("GET /events", eventsHandler)
+	mux.HandleFunc("GET /ws", wsNewsHandler)
+	mux.Handle("GET /static/", staticHandler())
+	mux.Handle("GET /metrics", web.MetricsHandler())
+	mux.HandleFunc("GET /healthz", healthzHandler)
+	mux.HandleFunc("GET /readyz", readyzHandler)
+
+	// Saved searches, muted keywords and hidden sources per user
+	if prefs, err = loadPrefs(*prefsFile); err != nil {
+		web.Fatal("loading preferences failed", err)
 	}
-	// Execute the page
-	t.Execute(w, p)
 
-}
+	// Webhook alerts are checked on every aggregation
+	if *alertRulesFile != "" {
+		if alerts, err = loadAlerter(*alertRulesFile, *alertStateFile); err != nil {
+			web.Fatal("loading alert rules failed", err)
+		}
+		go alerts.run(context.Background())
+	}
 
-func main() {
+	// Article summaries are fetched in the background as articles show up
+	if *enrichEnabled {
+		enrich = newEnricher()
+		go enrich.run(context.Background(), *enrichWorkers)
+	}
 
-	// Vid 17
-	http.HandleFunc("/agg/", newsAggHandler)
+	// Keep the aggregation fresh in the background
+	if *refreshInterval > 0 {
+		go agg.run(context.Background(), *refreshInterval)
+	}
 
 	// All function handlers should come before this
-	// Direct WS to listen on Port, nil handler, DefaultServeMux used
-	http.ListenAndServe(":8080", nil)
-
+	// Runs until interrupted, exits non-zero if the port can't be bound
+	web.Run(mux)
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+var (
+	enrichEnabled    = flag.Bool("enrich", false, "fetch each new article and show a summary of its text on /agg/")
+	enrichWorkers    = flag.Int("enrich-workers", 4, "articles fetched at once for summaries")
+	enrichTimeout    = flag.Duration("enrich-timeout", 15*time.Second, "max time to fetch one article for its summary")
+	summarySentences = flag.Int("summary-sentences", 3, "sentences in each article summary")
+)
+
+// Articles waiting for a worker, pages past the size limit are cut off
+const (
+	enrichQueueSize = 1024
+	enrichMaxBytes  = 2 << 20
+)
+
+var (
+	enrichResults = web.NewCounter("newsagg_enrich_total",
+		"Articles fetched for summaries, by result.", "result")
+	enrichDuration = web.NewHistogram("newsagg_enrich_duration_seconds",
+		"Time taken to fetch and summarize an article.", web.DefaultBuckets)
+)
+
+type enrichJob struct {
+	id, title, location string
+	source              string // sitemap that listed the article
+}
+
+// enricher fetches articles in the background and stores their summaries
+// on the aggregator. Each article is tried once while it stays aggregated.
+type enricher struct {
+	queue chan enrichJob
+
+	mu    sync.Mutex
+	tried map[string]bool // queued, in progress or done, by article ID
+}
+
+// nil unless --enrich is on
+var enrich *enricher
+
+func newEnricher() *enricher {
+	return &enricher{
+		queue: make(chan enrichJob, enrichQueueSize),
+		tried: make(map[string]bool),
+	}
+}
+
+// enqueue queues every article not tried yet and forgets the ones that
+// dropped out of the aggregation
+func (e *enricher) enqueue(newsMap map[string]NewsMap) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current := make(map[string]bool, len(newsMap))
+	for title, data := range newsMap {
+		current[data.ID] = true
+		if e.tried[data.ID] {
+			continue
+		}
+		select {
+		case e.queue <- enrichJob{id: data.ID, title: title, location: data.Location, source: data.Source}:
+			e.tried[data.ID] = true
+		default:
+			// Full, whatever didn't fit is queued on the next refresh
+			return
+		}
+	}
+	for id := range e.tried {
+		if !current[id] {
+			delete(e.tried, id)
+		}
+	}
+}
+
+// run starts the workers and waits for them to stop once ctx is done
+func (e *enricher) run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-e.queue:
+					e.process(ctx, job)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (e *enricher) process(ctx context.Context, job enrichJob) {
+	start := time.Now()
+	summary, err := fetchSummary(ctx, job.title, job.location, job.source)
+	enrichDuration.Observe(time.Since(start).Seconds())
+	switch {
+	case err != nil:
+		enrichResults.Inc("failed")
+		slog.Debug("could not summarize article", "id", job.id, "location", job.location, "err", err)
+	case summary == "":
+		enrichResults.Inc("empty")
+	default:
+		enrichResults.Inc("ok")
+		agg.setSummary(job.id, summary)
+	}
+}
+
+// enrichClient only follows redirects that stay on the article's host
+var enrichClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return checkArticleURL(req.URL, via[0].URL.Hostname())
+	},
+}
+
+// checkArticleURL refuses anything but http and https on the given host, so
+// a sitemap can't point the server at other hosts or at local files
+func checkArticleURL(u *url.URL, host string) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("not an http or https URL: %s", u.Redacted())
+	}
+	if !strings.EqualFold(u.Hostname(), host) {
+		return fmt.Errorf("%s is not on the sitemap's host %s", u.Redacted(), host)
+	}
+	return nil
+}
+
+// fetchSummary downloads the article page and summarizes its main text,
+// falling back to the page's own description
+// Only articles on the same host as the sitemap listing them are fetched
+func fetchSummary(ctx context.Context, title, location, source string) (string, error) {
+	src, err := url.Parse(source)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	if err := checkArticleURL(u, src.Hostname()); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *enrichTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "newsagg (+article summaries)")
+	req.Header.Set("Accept", "text/html")
+	resp, err := enrichClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", location, resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return "", errors.New("not an HTML page: " + mediaType)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, enrichMaxBytes))
+	if err != nil {
+		return "", err
+	}
+
+	page := extractText(string(body))
+	if summary := summarize(title, page.Text, *summarySentences); summary != "" {
+		return summary, nil
+	}
+	return page.Description, nil
+}
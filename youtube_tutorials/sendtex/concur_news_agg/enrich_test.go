@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchSummaryStaysOnHost(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	// localhost is the same server under another host name
+	other := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	mux.HandleFunc("/story", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<meta name="description" content="The storm is over.">`))
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other+"/story", http.StatusFound)
+	})
+
+	source := srv.URL + "/sitemap.xml"
+	tests := []struct {
+		name, location string
+		ok             bool
+	}{
+		{"same host", srv.URL + "/story", true},
+		{"other host", other + "/story", false},
+		{"redirect to another host", srv.URL + "/moved", false},
+		{"file URL", "file:///etc/passwd", false},
+		{"no scheme", "/story", false},
+	}
+	for _, tt := range tests {
+		summary, err := fetchSummary(context.Background(), "Storm", tt.location, source)
+		if tt.ok && (err != nil || summary != "The storm is over.") {
+			t.Errorf("%s: got %q %v, want the page's description", tt.name, summary, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: fetched %s for a sitemap on %s", tt.name, tt.location, srv.URL)
+		}
+	}
+}
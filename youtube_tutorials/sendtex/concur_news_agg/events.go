@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+// Events buffered per client before new ones are dropped
+var eventBuffer = flag.Int("event-buffer", 64, "articles buffered per /events client before new ones are dropped")
+
+// How often an idle stream gets a comment line so proxies don't time it out
+const eventHeartbeat = 30 * time.Second
+
+var eventsDropped = web.NewCounter("newsagg_events_dropped_total",
+	"Article events dropped because a subscriber was not keeping up.")
+var eventSubscribers = web.NewGauge("newsagg_event_subscribers",
+	"Clients currently subscribed to new article events.")
+
+// NewsEvent is a newly aggregated article as sent to subscribers
+type NewsEvent struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Keyword   string    `json:"keywords"`
+	Location  string    `json:"location"`
+	Source    string    `json:"source"`
+	FirstSeen time.Time `json:"first_seen"`
+	Language  string    `json:"language"`
+	Summary   string    `json:"summary,omitempty"`
+}
+
+func newNewsEvent(title string, data NewsMap) NewsEvent {
+	return NewsEvent{
+		ID:        data.ID,
+		Title:     title,
+		Keyword:   data.Keyword,
+		Location:  data.Location,
+		Source:    data.Source,
+		FirstSeen: data.FirstSeen,
+		Language:  data.Language,
+	}
+}
+
+// dropPolicy says what to do when a subscriber's buffer is full
+type dropPolicy int
+
+const (
+	dropNewest     dropPolicy = iota // the new event is lost
+	dropOldest                       // the oldest buffered event makes room for it
+	dropDisconnect                   // the subscriber is closed, it can reconnect and catch up
+)
+
+// subscription is one client's buffered feed of events
+// C is closed if the broker gives up on it under dropDisconnect
+type subscription struct {
+	C      chan NewsEvent
+	policy dropPolicy
+
+	mu      sync.Mutex
+	match   func(NewsEvent) bool // nil lets everything through
+	dropped uint64
+}
+
+func (s *subscription) setFilter(match func(NewsEvent) bool) {
+	s.mu.Lock()
+	s.match = match
+	s.mu.Unlock()
+}
+
+// droppedCount is how many events this subscriber missed so far
+func (s *subscription) droppedCount() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// offer hands the event to the subscriber without blocking
+// Returns false if the subscriber should be disconnected
+func (s *subscription) offer(ev NewsEvent) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.match != nil && !s.match(ev) {
+		return true
+	}
+	select {
+	case s.C <- ev:
+		return true
+	default:
+	}
+
+	s.dropped++
+	eventsDropped.Inc()
+	switch s.policy {
+	case dropOldest:
+		select {
+		case <-s.C:
+		default:
+		}
+		select {
+		case s.C <- ev:
+		default:
+		}
+	case dropDisconnect:
+		return false
+	}
+	return true
+}
+
+// newsBroker fans new articles out to every subscriber
+// Publishing never blocks, a subscriber whose buffer is full is dealt with
+// by its drop policy so one slow client can't hold up the aggregator
+type newsBroker struct {
+	mu   sync.Mutex
+	subs map[*subscription]struct{}
+}
+
+var broker = &newsBroker{subs: make(map[*subscription]struct{})}
+
+func (b *newsBroker) subscribe(buffer int, policy dropPolicy) *subscription {
+	sub := &subscription{C: make(chan NewsEvent, buffer), policy: policy}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	eventSubscribers.Inc()
+	return sub
+}
+
+func (b *newsBroker) unsubscribe(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		eventSubscribers.Dec()
+	}
+}
+
+func (b *newsBroker) publish(events []NewsEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ev := range events {
+		for sub := range b.subs {
+			if !sub.offer(ev) {
+				delete(b.subs, sub)
+				eventSubscribers.Dec()
+				close(sub.C)
+			}
+		}
+	}
+}
+
+// eventsHandler streams new articles as Server-Sent Events
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The stream stays open far longer than the server write timeout
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.Warn("could not lift write deadline for event stream", "err", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		slog.Error("event stream not supported", "err", err)
+		return
+	}
+
+	sub := broker.subscribe(*eventBuffer, dropNewest)
+	defer broker.unsubscribe(sub)
+	// Same articles left out as on the page, unless it was opened with ?all=1
+	if r.URL.Query().Get("all") != "1" {
+		sub.setFilter(prefs.get(profileID(r)).matcher())
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case ev := <-sub.C:
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "id: %s\nevent: article\ndata: %s\n\n", ev.ID, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
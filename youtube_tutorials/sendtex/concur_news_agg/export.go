@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+// Without a from date exports cover this much time before the to date
+const defaultExportDays = 7
+
+// exportArticle is one article in an export, the same fields in every format
+type exportArticle struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Keyword   string    `json:"keywords"`
+	Location  string    `json:"location"`
+	Source    string    `json:"source"`
+	Language  string    `json:"language"`
+	Published time.Time `json:"published,omitzero"`
+	FirstSeen time.Time `json:"first_seen"`
+	Summary   string    `json:"summary,omitempty"`
+}
+
+// date is what the export range is checked against, when it was published
+// or when we first saw it if the sitemap didn't say
+func (a exportArticle) date() time.Time {
+	if !a.Published.IsZero() {
+		return a.Published
+	}
+	return a.FirstSeen
+}
+
+// exportRange is the from and to of an export, to is exclusive
+type exportRange struct {
+	From, To time.Time
+}
+
+// exportOptions says what goes into an export
+type exportOptions struct {
+	Range  exportRange
+	Search string // same matching as the /agg/ search box
+	Lang   string
+	// Set when the range starts before the oldest article held, the
+	// export can't be complete before this
+	HeldFrom time.Time
+}
+
+// exportFormat writes articles out as one kind of file
+type exportFormat struct {
+	ext         string
+	contentType string
+	write       func(w io.Writer, opts exportOptions, articles []exportArticle) error
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv":   {ext: "csv", contentType: "text/csv; charset=utf-8", write: writeExportCSV},
+	"jsonl": {ext: "jsonl", contentType: "application/x-ndjson", write: writeExportJSONL},
+	"md":    {ext: "md", contentType: "text/markdown; charset=utf-8", write: writeExportDigest},
+}
+
+// parseExportRange reads the from and to dates, either a day like 2006-01-02
+// or an RFC 3339 time. A to day counts in full. Missing to is the end of
+// today and missing from is a week before to.
+func parseExportRange(from, to string, now time.Time) (exportRange, error) {
+	var rng exportRange
+	var err error
+	if to == "" {
+		rng.To = now.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	} else if rng.To, err = parseExportTime(to, true); err != nil {
+		return rng, fmt.Errorf("bad to date %q, want 2006-01-02 or an RFC 3339 time", to)
+	}
+	if from == "" {
+		rng.From = rng.To.AddDate(0, 0, -defaultExportDays)
+	} else if rng.From, err = parseExportTime(from, false); err != nil {
+		return rng, fmt.Errorf("bad from date %q, want 2006-01-02 or an RFC 3339 time", from)
+	}
+	if !rng.From.Before(rng.To) {
+		return rng, errors.New("from has to be before to")
+	}
+	return rng, nil
+}
+
+// A plain day is midnight UTC, or the midnight after it for the end of a range
+func parseExportTime(s string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// String is the range as days, the to day being the last one included
+func (rng exportRange) String() string {
+	last := rng.To.Add(-time.Nanosecond)
+	return rng.From.UTC().Format(time.DateOnly) + "_" + last.UTC().Format(time.DateOnly)
+}
+
+// heldFrom is the date of the oldest article in newsMap, zero if it's empty
+// Only the sitemaps and the --history are held, an export can't go further back
+func heldFrom(newsMap map[string]NewsMap) time.Time {
+	var oldest time.Time
+	for _, data := range newsMap {
+		d := exportArticle{Published: data.Published, FirstSeen: data.FirstSeen}.date()
+		if oldest.IsZero() || d.Before(oldest) {
+			oldest = d
+		}
+	}
+	return oldest
+}
+
+// checkHeld sets HeldFrom if the range starts before anything in newsMap
+func (opts *exportOptions) checkHeld(newsMap map[string]NewsMap) {
+	if oldest := heldFrom(newsMap); !oldest.IsZero() && opts.Range.From.Before(oldest) {
+		opts.HeldFrom = oldest
+	}
+}
+
+// exportArticles picks the articles in the range that match the search and
+// language, newest first
+func exportArticles(newsMap map[string]NewsMap, opts exportOptions, summary func(id string) string) []exportArticle {
+	search := strings.ToLower(opts.Search)
+	var articles []exportArticle
+	for title, data := range newsMap {
+		if search != "" &&
+			!strings.Contains(strings.ToLower(title), search) &&
+			!strings.Contains(strings.ToLower(data.Keyword), search) {
+			continue
+		}
+		if opts.Lang != "" && data.Language != opts.Lang {
+			continue
+		}
+		a := exportArticle{
+			ID:        data.ID,
+			Title:     title,
+			Keyword:   data.Keyword,
+			Location:  data.Location,
+			Source:    data.Source,
+			Language:  data.Language,
+			Published: data.Published,
+			FirstSeen: data.FirstSeen,
+			Summary:   summary(data.ID),
+		}
+		if d := a.date(); d.Before(opts.Range.From) || !d.Before(opts.Range.To) {
+			continue
+		}
+		articles = append(articles, a)
+	}
+	sort.Slice(articles, func(i, j int) bool {
+		di, dj := articles[i].date(), articles[j].date()
+		if !di.Equal(dj) {
+			return di.After(dj)
+		}
+		return articles[i].Title < articles[j].Title
+	})
+	return articles
+}
+
+// Times in CSV cells, empty if unknown
+func exportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvCell stops spreadsheets running a cell as a formula, titles and
+// keywords come from the sitemaps so anyone could have written them
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func writeExportCSV(w io.Writer, _ exportOptions, articles []exportArticle) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "title", "keywords", "location", "source", "language", "published", "first_seen", "summary"})
+	for _, a := range articles {
+		cw.Write([]string{a.ID, csvCell(a.Title), csvCell(a.Keyword), csvCell(a.Location), csvCell(a.Source), csvCell(a.Language),
+			exportTime(a.Published), exportTime(a.FirstSeen), csvCell(a.Summary)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeExportJSONL(w io.Writer, _ exportOptions, articles []exportArticle) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, a := range articles {
+		if err := enc.Encode(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Group heading for articles without keywords
+const digestOther = "Other"
+
+// writeExportDigest writes a Markdown reading list with a section per
+// keyword. Each article goes under whichever of its keywords the most
+// articles in the export share, so it's listed once and the sections that
+// come out are the big stories of the week.
+func writeExportDigest(w io.Writer, opts exportOptions, articles []exportArticle) error {
+	shared := map[string]int{}
+	for _, a := range articles {
+		for _, k := range splitKeywords(a.Keyword) {
+			shared[k]++
+		}
+	}
+	groups := map[string][]exportArticle{}
+	for _, a := range articles {
+		group := digestOther
+		for _, k := range splitKeywords(a.Keyword) {
+			if group == digestOther || shared[k] > shared[group] || shared[k] == shared[group] && k < group {
+				group = k
+			}
+		}
+		groups[group] = append(groups[group], a)
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	// Biggest sections first, Other always last
+	sort.Slice(names, func(i, j int) bool {
+		a, b := names[i], names[j]
+		if (a == digestOther) != (b == digestOther) {
+			return b == digestOther
+		}
+		if len(groups[a]) != len(groups[b]) {
+			return len(groups[a]) > len(groups[b])
+		}
+		return a < b
+	})
+
+	bw := bufio.NewWriter(w)
+	last := opts.Range.To.Add(-time.Nanosecond)
+	fmt.Fprintf(bw, "# %s\n\n", markdownEscape(*pageTitle))
+	fmt.Fprintf(bw, "%s to %s, %d articles", opts.Range.From.UTC().Format("2 Jan 2006"), last.UTC().Format("2 Jan 2006"), len(articles))
+	if opts.Search != "" {
+		fmt.Fprintf(bw, " matching \"%s\"", markdownEscape(opts.Search))
+	}
+	if opts.Lang != "" {
+		fmt.Fprintf(bw, " in %s", languageName(opts.Lang))
+	}
+	bw.WriteString(".\n")
+	if !opts.HeldFrom.IsZero() {
+		fmt.Fprintf(bw, "\n_Only articles from %s on are held, anything earlier in the range is missing._\n",
+			opts.HeldFrom.UTC().Format("2 Jan 2006 15:04 MST"))
+	}
+	for _, name := range names {
+		fmt.Fprintf(bw, "\n## %s (%d)\n\n", markdownEscape(name), len(groups[name]))
+		for _, a := range groups[name] {
+			row := NewsRow{Location: a.Location}
+			fmt.Fprintf(bw, "- [%s](<%s>) %s, %s\n", markdownEscape(a.Title), markdownLinkEscaper.Replace(a.Location),
+				markdownEscape(row.Outlet()), a.date().UTC().Format("2 Jan 2006"))
+			if a.Summary != "" {
+				fmt.Fprintf(bw, "  > %s\n", markdownEscape(a.Summary))
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Characters that would otherwise turn into Markdown formatting
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "\n", " ",
+)
+
+// A <link> ends at >, can't span lines and \ escapes inside it, so those
+// are percent encoded. Sitemaps are someone else's data
+var markdownLinkEscaper = strings.NewReplacer(
+	"<", "%3C", ">", "%3E", `\`, "%5C", "\n", "%0A", "\r", "%0D",
+)
+
+func markdownEscape(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// ExportLink is where to download the news on the page in a format, the
+// search and language carry over but not the sort or page
+func (p NewsAggPage) ExportLink(format string) string {
+	v := url.Values{}
+	if p.Query.Search != "" {
+		v.Set("q", p.Query.Search)
+	}
+	if p.Query.Lang != "" {
+		v.Set("lang", p.Query.Lang)
+	}
+	if p.Query.All {
+		v.Set("all", "1")
+	}
+	link := "/export/news." + exportFormats[format].ext
+	if len(v) > 0 {
+		link += "?" + v.Encode()
+	}
+	return link
+}
+
+// exportHandler downloads the aggregated news in a format at
+// /export/news.csv and friends. It takes from, to, q, lang and all, and
+// leaves out what the user's preferences hide like /agg/ does.
+// Articles come from the sitemaps and the --history, a range starting
+// before the oldest of them gets an X-Export-Held-From header.
+func exportHandler(format string) http.HandlerFunc {
+	f := exportFormats[format]
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query()
+		rng, err := parseExportRange(v.Get("from"), v.Get("to"), time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		newsMap, err := agg.news(r.Context())
+		if err != nil && !errors.Is(err, errNoArticles) {
+			http.Error(w, "Could not fetch the news sitemaps", http.StatusBadGateway)
+			return
+		}
+		newsMap = agg.withHistory(newsMap)
+		opts := exportOptions{
+			Range:  rng,
+			Search: strings.TrimSpace(v.Get("q")),
+			Lang:   strings.ToLower(strings.TrimSpace(v.Get("lang"))),
+		}
+		opts.checkHeld(newsMap)
+		newsMap, _, _ = userNews(r, newsMap, v.Get("all") == "1")
+		articles := exportArticles(newsMap, opts, agg.summary)
+
+		// CSV and JSON Lines have nowhere to say the range isn't covered, so it's a header
+		if !opts.HeldFrom.IsZero() {
+			w.Header().Set("X-Export-Held-From", opts.HeldFrom.UTC().Format(time.RFC3339))
+		}
+		w.Header().Set("Content-Type", f.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="news-%s.%s"`, rng, f.ext))
+		w.Header().Set("Cache-Control", "private, no-store")
+		if err := f.write(w, opts, articles); err != nil {
+			// Headers are gone by now, all we can do is log it
+			slog.Error("export failed", "format", format, "err", err, "request_id", web.RequestIDFrom(r.Context()))
+		}
+	}
+}
+
+// runExport is the export subcommand, it aggregates once and writes the
+// file, for cron jobs that don't want to run the server
+//
+//	concur_news_agg [flags] export -format md -from 2026-10-12 -o digest.md
+func runExport(args []string) error {
+	cmd := flag.NewFlagSet("export", flag.ExitOnError)
+	format := cmd.String("format", "csv", "csv, jsonl or md")
+	from := cmd.String("from", "", "first day to include, 2006-01-02 or RFC 3339, default a week before -to")
+	to := cmd.String("to", "", "last day to include, 2006-01-02 or RFC 3339, default now")
+	search := cmd.String("q", "", "only articles with this in the title or keywords")
+	lang := cmd.String("lang", "", "only articles in this language")
+	out := cmd.String("o", "", "file to write, default stdout")
+	if err := cmd.Parse(args); err != nil {
+		return err
+	}
+	f, ok := exportFormats[*format]
+	if !ok {
+		return fmt.Errorf("unknown format %q, want csv, jsonl or md", *format)
+	}
+	rng, err := parseExportRange(*from, *to, time.Now())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	newsMap, err := agg.refresh(ctx)
+	if err != nil {
+		return err
+	}
+	opts := exportOptions{Range: rng, Search: *search, Lang: strings.ToLower(*lang)}
+	opts.checkHeld(newsMap)
+	if !opts.HeldFrom.IsZero() {
+		slog.Warn("export range starts before the oldest article in the sitemaps, it won't be complete",
+			"from", rng.From, "held_from", opts.HeldFrom)
+	}
+	articles := exportArticles(newsMap, opts, agg.summary)
+	// There's no background enricher to wait on, summaries are fetched here
+	if *enrichEnabled {
+		summarizeExport(ctx, articles, *enrichWorkers)
+	}
+
+	if *out == "" {
+		return f.write(os.Stdout, opts, articles)
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := f.write(file, opts, articles); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// summarizeExport fills in the summaries of the exported articles, a few at a
+// time. Articles that can't be summarized are left without one.
+func summarizeExport(ctx context.Context, articles []exportArticle, workers int) {
+	sem := make(chan struct{}, max(workers, 1))
+	var wg sync.WaitGroup
+	for i := range articles {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			summary, err := fetchSummary(ctx, articles[i].Title, articles[i].Location, articles[i].Source)
+			if err != nil {
+				slog.Debug("could not summarize article", "id", articles[i].ID, "location", articles[i].Location, "err", err)
+				return
+			}
+			articles[i].Summary = summary
+		}()
+	}
+	wg.Wait()
+}
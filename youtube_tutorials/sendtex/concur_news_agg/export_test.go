@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportCSVFormulas(t *testing.T) {
+	articles := []exportArticle{
+		{ID: "a", Title: "=HYPERLINK(\"http://evil.example\")", Keyword: "+1, politics", Summary: "-2 points"},
+		{ID: "b", Title: "@SUM(A1:A2)", Keyword: "\tcmd", Summary: "Up 3% - a normal sentence"},
+	}
+	var buf bytes.Buffer
+	if err := writeExportCSV(&buf, exportOptions{}, articles); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][3]string{
+		{"'=HYPERLINK(\"http://evil.example\")", "'+1, politics", "'-2 points"},
+		{"'@SUM(A1:A2)", "'\tcmd", "Up 3% - a normal sentence"},
+	}
+	for i, w := range want {
+		row := records[i+1]
+		if got := [3]string{row[1], row[2], row[8]}; got != w {
+			t.Errorf("row %d: got %q, want %q", i, got, w)
+		}
+	}
+}
+
+func TestExportMarksRangeNotHeld(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 12, 0, 0, 0, time.UTC) }
+	newsMap := map[string]NewsMap{
+		"Storm hits the coast": {ID: "a", Keyword: "weather", Published: day(14), FirstSeen: day(15)},
+		"Markets rally":        {ID: "b", Keyword: "economy", FirstSeen: day(16)},
+	}
+
+	covered := exportOptions{Range: exportRange{From: day(14), To: day(18)}}
+	covered.checkHeld(newsMap)
+	if !covered.HeldFrom.IsZero() {
+		t.Errorf("range from the oldest article marked as not held from %v", covered.HeldFrom)
+	}
+
+	opts := exportOptions{Range: exportRange{From: day(10), To: day(18)}}
+	opts.checkHeld(newsMap)
+	if !opts.HeldFrom.Equal(day(14)) {
+		t.Fatalf("HeldFrom %v, want the oldest article's date %v", opts.HeldFrom, day(14))
+	}
+	var buf bytes.Buffer
+	if err := writeExportDigest(&buf, opts, exportArticles(newsMap, opts, func(string) string { return "" })); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Only articles from 14 Oct 2026 12:00 UTC on are held") {
+		t.Errorf("digest doesn't say the range isn't covered:\n%s", buf.String())
+	}
+}
+
+func TestExportDigestLinks(t *testing.T) {
+	published := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	articles := []exportArticle{
+		{ID: "a", Title: "Storm", Location: "https://news.example.com/storm>)[x](javascript:alert(1)", Published: published},
+		{ID: "b", Title: "Markets", Location: "https://news.example.com/a\n## Injected\\", Published: published},
+	}
+	var buf bytes.Buffer
+	opts := exportOptions{Range: exportRange{From: published, To: published.Add(24 * time.Hour)}}
+	if err := writeExportDigest(&buf, opts, articles); err != nil {
+		t.Fatal(err)
+	}
+	digest := buf.String()
+	for _, want := range []string{
+		"[Storm](<https://news.example.com/storm%3E)[x](javascript:alert(1)>)",
+		"[Markets](<https://news.example.com/a%0A## Injected%5C>)",
+	} {
+		if !strings.Contains(digest, want) {
+			t.Errorf("digest doesn't have %q:\n%s", want, digest)
+		}
+	}
+	if strings.Contains(digest, "\n## Injected") {
+		t.Errorf("a location started a heading:\n%s", digest)
+	}
+}
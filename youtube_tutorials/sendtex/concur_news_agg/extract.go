@@ -0,0 +1,467 @@
+package main
+
+import (
+	"html"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Readability style text extraction: every paragraph scores points for the
+// element around it, longer paragraphs with more commas score more. The
+// element with the best score, after a nudge from its class and id, is taken
+// to be the article body. There's no HTML parser in the standard library so
+// the markup is read with a forgiving tokenizer that only tracks what the
+// scoring needs.
+
+// Elements that never hold article text
+var extractSkipTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true,
+	"nav": true, "header": true, "footer": true, "aside": true, "form": true,
+	"iframe": true, "button": true, "select": true, "figcaption": true,
+}
+
+// Elements with no closing tag
+var voidTags = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+var (
+	positiveClass = regexp.MustCompile(`(?i)article|body|content|entry|main|page|post|story|text`)
+	negativeClass = regexp.MustCompile(`(?i)comment|footer|sidebar|share|social|promo|related|sponsor|advert|\bads?\b|newsletter|subscribe|popup|cookie|menu|breadcrumb|caption|byline`)
+)
+
+// Paragraphs shorter than this are usually captions, bylines or buttons
+const minParagraphLen = 25
+
+// Real pages nest a few dozen deep. Past maxOpenElements new elements aren't
+// tracked, and an end tag only closes one of the innermost maxEndTagSearch,
+// so junk markup can't make every end tag walk a huge stack.
+const (
+	maxOpenElements = 256
+	maxEndTagSearch = 32
+)
+
+type htmlElement struct {
+	tag    string
+	parent int // index in the element list, -1 for the root
+	weight float64
+	score  float64
+	skip   bool // inside an element that never holds article text
+}
+
+type paragraph struct {
+	text    string
+	element int // element the paragraph sits in
+}
+
+// extracted is what extractText found on a page
+type extracted struct {
+	Text        string // main text, paragraphs separated by blank lines
+	Description string // the page's own meta description, if any
+}
+
+// extractText finds the main readable text of an HTML page
+func extractText(page string) extracted {
+	var out extracted
+	elements := []htmlElement{}
+	var open []int // stack of open element indexes
+	var paras []paragraph
+
+	// The paragraph being read, if any
+	inPara := -1 // index in open of the <p>
+	var text, linkText strings.Builder
+	linkDepth := 0
+
+	current := func() int {
+		if len(open) == 0 {
+			return -1
+		}
+		return open[len(open)-1]
+	}
+	endPara := func() {
+		if inPara < 0 {
+			return
+		}
+		t := collapseSpace(text.String())
+		// Mostly links means navigation or a list of other stories
+		if len(t) >= minParagraphLen && float64(len(collapseSpace(linkText.String()))) < 0.5*float64(len(t)) {
+			parent := -1
+			if inPara > 0 {
+				parent = open[inPara-1]
+			}
+			paras = append(paras, paragraph{text: t, element: parent})
+		}
+		inPara = -1
+		text.Reset()
+		linkText.Reset()
+	}
+	closeTo := func(depth int) {
+		if inPara >= depth {
+			endPara()
+		}
+		for len(open) > depth {
+			if elements[open[len(open)-1]].tag == "a" {
+				linkDepth = max(linkDepth-1, 0)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+
+	for i := 0; i < len(page); {
+		lt := strings.IndexByte(page[i:], '<')
+		if lt < 0 {
+			lt = len(page) - i
+		}
+		if lt > 0 && inPara >= 0 {
+			chunk := html.UnescapeString(page[i : i+lt])
+			text.WriteString(chunk)
+			if linkDepth > 0 {
+				linkText.WriteString(chunk)
+			}
+		}
+		i += lt
+		if i >= len(page) {
+			break
+		}
+
+		// Comments, doctype and processing instructions
+		if strings.HasPrefix(page[i:], "<!--") {
+			end := strings.Index(page[i+4:], "-->")
+			if end < 0 {
+				break
+			}
+			i += 4 + end + 3
+			continue
+		}
+		gt := strings.IndexByte(page[i:], '>')
+		if gt < 0 {
+			break
+		}
+		raw := page[i+1 : i+gt]
+		i += gt + 1
+		if raw == "" || raw[0] == '!' || raw[0] == '?' {
+			continue
+		}
+
+		if raw[0] == '/' {
+			name := strings.ToLower(strings.TrimSpace(strings.TrimRight(raw[1:], " \t\n/")))
+			for d := len(open) - 1; d >= max(len(open)-maxEndTagSearch, 0); d-- {
+				if elements[open[d]].tag == name {
+					closeTo(d)
+					break
+				}
+			}
+			continue
+		}
+
+		name, attrs := parseTag(raw)
+		if name == "" {
+			continue
+		}
+		if name == "meta" {
+			key := strings.ToLower(attrs["name"] + attrs["property"])
+			if (key == "description" || key == "og:description") && out.Description == "" {
+				out.Description = collapseSpace(attrs["content"])
+			}
+		}
+		// Script and style bodies aren't markup, skip straight past them
+		if name == "script" || name == "style" || name == "textarea" || name == "title" {
+			end := indexFold(page[i:], "</"+name)
+			if end < 0 {
+				break
+			}
+			i += end
+			continue
+		}
+		if voidTags[name] || strings.HasSuffix(raw, "/") {
+			if name == "br" && inPara >= 0 {
+				text.WriteByte(' ')
+			}
+			continue
+		}
+
+		// A new block closes an open paragraph, as browsers do
+		if inPara >= 0 && (name == "p" || name == "div" || name == "section" || name == "article" ||
+			name == "ul" || name == "ol" || name == "table" || strings.HasPrefix(name, "h")) {
+			closeTo(inPara)
+		}
+
+		if len(open) >= maxOpenElements {
+			continue
+		}
+		parent := current()
+		el := htmlElement{tag: name, parent: parent}
+		if parent >= 0 {
+			el.skip = elements[parent].skip
+		}
+		el.skip = el.skip || extractSkipTags[name]
+		if class := attrs["class"] + " " + attrs["id"]; strings.TrimSpace(class) != "" {
+			if positiveClass.MatchString(class) {
+				el.weight += 25
+			}
+			if negativeClass.MatchString(class) {
+				el.weight -= 25
+			}
+		}
+		elements = append(elements, el)
+		open = append(open, len(elements)-1)
+
+		switch {
+		case name == "p" && !el.skip && inPara < 0:
+			inPara = len(open) - 1
+		case name == "a":
+			linkDepth++
+		}
+	}
+	closeTo(0)
+
+	// Score the elements around each paragraph, half for the grandparent
+	for _, p := range paras {
+		score := 1 + float64(strings.Count(p.text, ",")) + math.Min(float64(len(p.text)/100), 3)
+		if e := p.element; e >= 0 {
+			elements[e].score += score
+			if gp := elements[e].parent; gp >= 0 {
+				elements[gp].score += score / 2
+			}
+		}
+	}
+	best, bestScore := -1, 0.0
+	for i, el := range elements {
+		if el.score == 0 || el.skip {
+			continue
+		}
+		if s := el.score + el.weight; best < 0 || s > bestScore {
+			best, bestScore = i, s
+		}
+	}
+
+	var body []string
+	for _, p := range paras {
+		if best < 0 || isWithin(elements, p.element, best) {
+			body = append(body, p.text)
+		}
+	}
+	out.Text = strings.Join(body, "\n\n")
+	return out
+}
+
+// isWithin says if element e is top or one of its descendants
+func isWithin(elements []htmlElement, e, top int) bool {
+	for ; e >= 0; e = elements[e].parent {
+		if e == top {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTag splits `a href="x" class=y` into the lower case tag name and attributes
+func parseTag(raw string) (string, map[string]string) {
+	raw = strings.TrimSuffix(raw, "/")
+	end := strings.IndexFunc(raw, unicode.IsSpace)
+	if end < 0 {
+		end = len(raw)
+	}
+	name := strings.ToLower(raw[:end])
+	attrs := map[string]string{}
+	rest := raw[end:]
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			break
+		}
+		n := strings.IndexAny(rest, "= \t\n\r")
+		if n < 0 {
+			attrs[strings.ToLower(rest)] = ""
+			break
+		}
+		key := strings.ToLower(rest[:n])
+		rest = strings.TrimLeftFunc(rest[n:], unicode.IsSpace)
+		if !strings.HasPrefix(rest, "=") {
+			attrs[key] = ""
+			continue
+		}
+		rest = strings.TrimLeftFunc(rest[1:], unicode.IsSpace)
+		var value string
+		if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+			q := strings.IndexByte(rest[1:], rest[0])
+			if q < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:1+q], rest[2+q:]
+			}
+		} else {
+			v := strings.IndexFunc(rest, unicode.IsSpace)
+			if v < 0 {
+				v = len(rest)
+			}
+			value, rest = rest[:v], rest[v:]
+		}
+		attrs[key] = html.UnescapeString(value)
+	}
+	return name, attrs
+}
+
+// indexFold is strings.Index ignoring ASCII case
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Words too common to say what a sentence is about
+var stopWords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`a about above after again against all also am an and any are as at be
+		because been before being below between both but by can could did do does doing down during each
+		few for from further had has have having he her here hers him his how i if in into is it its itself
+		just me more most my no nor not now of off on once only or other our ours out over own said same
+		says she should so some such than that the their theirs them then there these they this those
+		through to too under until up very was we were what when where which while who whom why will with
+		would you your yours year years new one two`) {
+		stopWords[w] = true
+	}
+}
+
+// Words before a full stop that don't end a sentence
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "st": true, "jr": true, "sr": true, "vs": true,
+	"inc": true, "co": true, "corp": true, "ltd": true, "gov": true, "sen": true, "rep": true,
+	"gen": true, "lt": true, "col": true, "no": true, "u.s": true, "u.k": true, "a.m": true, "p.m": true,
+	"jan": true, "feb": true, "aug": true, "sept": true, "oct": true, "nov": true, "dec": true,
+}
+
+// splitSentences breaks text on . ! or ? followed by a space and a capital,
+// a digit or a quote, skipping common abbreviations
+func splitSentences(text string) []string {
+	var out []string
+	for _, para := range strings.Split(text, "\n\n") {
+		start := 0
+		for i := 0; i < len(para); i++ {
+			c := para[i]
+			if c != '.' && c != '!' && c != '?' {
+				continue
+			}
+			// Closing quotes and brackets belong to the sentence
+			end := i + 1
+			for end < len(para) && strings.IndexByte(`"')]`, para[end]) >= 0 {
+				end++
+			}
+			if end < len(para) && para[end] != ' ' {
+				continue
+			}
+			next, _ := utf8.DecodeRuneInString(strings.TrimLeft(para[end:], " "))
+			if end < len(para) && !unicode.IsUpper(next) && !unicode.IsDigit(next) && !strings.ContainsRune("\"'“‘", next) {
+				continue
+			}
+			if c == '.' {
+				word := para[start:i]
+				if sp := strings.LastIndexByte(word, ' '); sp >= 0 {
+					word = word[sp+1:]
+				}
+				if abbreviations[strings.ToLower(strings.Trim(word, `"'(`))] {
+					continue
+				}
+			}
+			if s := strings.TrimSpace(para[start:end]); s != "" {
+				out = append(out, s)
+			}
+			start = end
+			i = end - 1
+		}
+		if s := strings.TrimSpace(para[start:]); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// contentWords are the lower case words of s that aren't stop words
+func contentWords(s string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}) {
+		w = strings.Trim(w, "'")
+		if utf8.RuneCountInString(w) > 2 && !stopWords[w] {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// summarize picks the n sentences that best represent the text, in the order
+// they appear. Sentences score by how frequent their words are across the
+// whole text, with a bonus for sharing words with the title and for coming
+// early, as news puts the main point first.
+func summarize(title, text string, n int) string {
+	sentences := splitSentences(text)
+	if len(sentences) == 0 || n <= 0 {
+		return ""
+	}
+
+	freq := map[string]float64{}
+	words := make([][]string, len(sentences))
+	top := 0.0
+	for i, s := range sentences {
+		words[i] = contentWords(s)
+		for _, w := range words[i] {
+			freq[w]++
+			top = math.Max(top, freq[w])
+		}
+	}
+	inTitle := map[string]bool{}
+	for _, w := range contentWords(title) {
+		inTitle[w] = true
+	}
+
+	type ranked struct {
+		index int
+		score float64
+	}
+	var ranks []ranked
+	for i, ws := range words {
+		// Fragments and run-on lists make poor summaries
+		if len(ws) < 4 || len(strings.Fields(sentences[i])) > 60 {
+			continue
+		}
+		score, shared := 0.0, 0
+		for _, w := range ws {
+			score += freq[w] / top
+			if inTitle[w] {
+				shared++
+			}
+		}
+		score /= float64(len(ws))
+		score *= 1 + float64(shared)/float64(max(len(inTitle), 1))
+		if i < 3 {
+			score *= 1.2
+		}
+		ranks = append(ranks, ranked{i, score})
+	}
+	if len(ranks) == 0 {
+		return ""
+	}
+	sort.SliceStable(ranks, func(a, b int) bool { return ranks[a].score > ranks[b].score })
+	ranks = ranks[:min(n, len(ranks))]
+	sort.Slice(ranks, func(a, b int) bool { return ranks[a].index < ranks[b].index })
+
+	picked := make([]string, len(ranks))
+	for i, r := range ranks {
+		picked[i] = sentences[r.index]
+	}
+	return strings.Join(picked, " ")
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtractText(t *testing.T) {
+	tests := []struct {
+		name, page string
+		text, desc string
+	}{
+		{
+			name: "article body over sidebar",
+			page: `<html><body>
+				<nav><a href="/">Home</a> <a href="/world">World</a></nav>
+				<div class="sidebar"><p>Sign up for our newsletter, it comes out every morning.</p></div>
+				<div class="article-body">
+					<p>The storm reached the coast on Tuesday, flooding roads and homes.</p>
+					<p>Officials said the clean up would take weeks, maybe months.</p>
+				</div>
+				<footer><p>Copyright the newspaper, all rights reserved, since 1877.</p></footer>
+			</body></html>`,
+			text: "The storm reached the coast on Tuesday, flooding roads and homes.\n\n" +
+				"Officials said the clean up would take weeks, maybe months.",
+		},
+		{
+			name: "script and style bodies skipped",
+			page: `<style>p { color: red }</style><p>Some real text that is long enough to count.` +
+				`<script>var x = "<p>not article text at all</p>";</script></p>`,
+			text: "Some real text that is long enough to count.",
+		},
+		{
+			name: "entities unescaped and spaces collapsed",
+			page: "<p>Fish &amp; chips   are\n back, says the chef &quot;Sam&quot;.</p>",
+			text: `Fish & chips are back, says the chef "Sam".`,
+		},
+		{
+			name: "mostly links dropped",
+			page: `<p><a href="/more">Read more stories from around the world here</a></p>` +
+				`<p>A real paragraph with enough text in it.</p>`,
+			text: "A real paragraph with enough text in it.",
+		},
+		{
+			name: "unclosed paragraphs",
+			page: "<div><p>First paragraph without an end tag here.<p>Second paragraph without an end tag too.</div>",
+			text: "First paragraph without an end tag here.\n\nSecond paragraph without an end tag too.",
+		},
+		{
+			name: "upper case tags and br",
+			page: "<P>Line one<BR>line two is long enough to be kept.</P>",
+			text: "Line one line two is long enough to be kept.",
+		},
+		{
+			name: "short paragraphs and comments",
+			page: "<p>Photo: AP</p><!-- <p>A commented out paragraph, long enough.</p> -->",
+		},
+		{
+			name: "meta description",
+			page: `<head><meta name="description" content="  A short  summary "><meta property="og:description" content="later"></head>`,
+			desc: "A short summary",
+		},
+		{
+			name: "unterminated tag",
+			page: "<p>Text that never gets its closing angle bracket <a href=",
+			text: "Text that never gets its closing angle bracket",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractText(tt.page)
+			if got.Text != tt.text {
+				t.Errorf("text %q, want %q", got.Text, tt.text)
+			}
+			if got.Description != tt.desc {
+				t.Errorf("description %q, want %q", got.Description, tt.desc)
+			}
+		})
+	}
+}
+
+func TestExtractTextDeepNesting(t *testing.T) {
+	para := "<p>Deep in the page but still an article paragraph.</p>"
+
+	// A sane depth still finds the text
+	page := strings.Repeat("<div>", 100) + para + strings.Repeat("</div>", 100)
+	if got := extractText(page).Text; got != "Deep in the page but still an article paragraph." {
+		t.Errorf("100 deep: got %q", got)
+	}
+
+	// Junk nesting with stray end tags has to stay linear, this took over a
+	// minute when every end tag walked the whole stack
+	page = strings.Repeat("<div>", 200000) + para + strings.Repeat("</b>", 200000)
+	done := make(chan struct{})
+	go func() {
+		extractText(page)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("extractText still running on 200000 nested divs")
+	}
+}
+
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		raw   string
+		name  string
+		attrs map[string]string
+	}{
+		{"p", "p", map[string]string{}},
+		{"IMG SRC=a.png /", "img", map[string]string{"src": "a.png"}},
+		{`a href="/x?a=1&amp;b=2" class='story link'`, "a", map[string]string{"href": "/x?a=1&b=2", "class": "story link"}},
+		{"input disabled type = text", "input", map[string]string{"disabled": "", "type": "text"}},
+		{`div id="unclosed`, "div", map[string]string{"id": "unclosed"}},
+	}
+	for _, tt := range tests {
+		name, attrs := parseTag(tt.raw)
+		if name != tt.name || !maps.Equal(attrs, tt.attrs) {
+			t.Errorf("parseTag(%q) = %q %v, want %q %v", tt.raw, name, attrs, tt.name, tt.attrs)
+		}
+	}
+}
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		name, text string
+		want       []string
+	}{
+		{"plain", "The storm hit. Roads flooded! Will it rain again?",
+			[]string{"The storm hit.", "Roads flooded!", "Will it rain again?"}},
+		{"abbreviations", "Mr. Smith went to Washington. He met Dr. Jones.",
+			[]string{"Mr. Smith went to Washington.", "He met Dr. Jones."}},
+		{"decimals", "Prices rose 3.5 percent. Markets fell.",
+			[]string{"Prices rose 3.5 percent.", "Markets fell."}},
+		{"lower case after a stop", "Use e.g. this one. Next sentence.",
+			[]string{"Use e.g. this one.", "Next sentence."}},
+		{"closing quotes", `She said "Stop." Then she left.`,
+			[]string{`She said "Stop."`, "Then she left."}},
+		{"paragraphs", "First paragraph without a stop\n\nSecond one",
+			[]string{"First paragraph without a stop", "Second one"}},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		if got := splitSentences(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	text := "The storm flooded coastal towns across the region on Tuesday night. " +
+		"Local bakeries reported record sales of bread and pastries this week. " +
+		"Emergency crews rescued residents trapped in flooded coastal homes. " +
+		"Forecasters expect the storm to weaken over the coastal region tomorrow."
+	tests := []struct {
+		name, title, text string
+		n                 int
+		want              string
+	}{
+		{"picks the storm sentences in order", "Storm floods coastal towns", text, 2,
+			"The storm flooded coastal towns across the region on Tuesday night. " +
+				"Forecasters expect the storm to weaken over the coastal region tomorrow."},
+		{"n past the sentence count", "Storm floods coastal towns",
+			"Emergency crews rescued residents trapped in flooded coastal homes.", 5,
+			"Emergency crews rescued residents trapped in flooded coastal homes."},
+		{"fragments only", "Storm", "Yes. No way. Maybe so.", 3, ""},
+		{"no sentences wanted", "Storm", text, 0, ""},
+		{"no text", "Storm", "", 3, ""},
+	}
+	for _, tt := range tests {
+		if got := summarize(tt.title, tt.text, tt.n); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup coalesces concurrent calls for the same key into one, so ten
+// requests landing together fetch and parse each sitemap once between them
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+type flightCall[T any] struct {
+	done    chan struct{} // closed once val and err are set
+	val     T
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// do runs fn for key unless a call for it is already in flight, in which
+// case it waits for that one. shared says if the result came from another caller.
+// Each caller stops waiting when its own ctx is done. The call itself only
+// gets cancelled once every caller waiting on it has gone.
+func (g *flightGroup[T]) do(ctx context.Context, key string, fn func(context.Context) (T, error)) (val T, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[T])
+	}
+	c, shared := g.calls[key]
+	if !shared {
+		// Keep the first caller's values but not its cancellation
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &flightCall[T]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go g.run(callCtx, key, c, fn)
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, shared, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			// Callers arriving now start afresh instead of joining a cancelled call
+			g.forget(key, c)
+		}
+		g.mu.Unlock()
+		var zero T
+		return zero, shared, ctx.Err()
+	}
+}
+
+func (g *flightGroup[T]) run(ctx context.Context, key string, c *flightCall[T], fn func(context.Context) (T, error)) {
+	c.val, c.err = fn(ctx)
+	c.cancel()
+	g.mu.Lock()
+	g.forget(key, c)
+	g.mu.Unlock()
+	close(c.done)
+}
+
+// Must be called with g.mu held
+func (g *flightGroup[T]) forget(key string, c *flightCall[T]) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForWaiters blocks until n callers are waiting on key's call
+func waitForWaiters[T any](t *testing.T, g *flightGroup[T], key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		g.mu.Lock()
+		c := g.calls[key]
+		got := c != nil && c.waiters == n
+		g.mu.Unlock()
+		if got {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("never saw %d callers waiting on %s", n, key)
+}
+
+func TestFlightShared(t *testing.T) {
+	var g flightGroup[string]
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		calls.Add(1)
+		<-release
+		return "index", nil
+	}
+
+	const callers = 5
+	var wg sync.WaitGroup
+	var sharedCount atomic.Int32
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, shared, err := g.do(context.Background(), "k", fn)
+			if val != "index" || err != nil {
+				t.Errorf("got %q %v, want index", val, err)
+			}
+			if shared {
+				sharedCount.Add(1)
+			}
+		}()
+	}
+	waitForWaiters(t, &g, "k", callers)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("fn ran %d times, want once", n)
+	}
+	if n := sharedCount.Load(); n != callers-1 {
+		t.Errorf("%d callers shared the result, want %d", n, callers-1)
+	}
+	if len(g.calls) != 0 {
+		t.Errorf("%d calls still held after finishing", len(g.calls))
+	}
+}
+
+func TestFlightCallerCancelled(t *testing.T) {
+	var g flightGroup[string]
+	release := make(chan struct{})
+	callCancelled := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		select {
+		case <-release:
+			return "index", nil
+		case <-ctx.Done():
+			close(callCancelled)
+			return "", ctx.Err()
+		}
+	}
+
+	// The first caller starts the call then gives up, the second keeps waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, _, err := g.do(ctx, "k", fn)
+		first <- err
+	}()
+	waitForWaiters(t, &g, "k", 1)
+	second := make(chan string, 1)
+	go func() {
+		val, _, _ := g.do(context.Background(), "k", fn)
+		second <- val
+	}()
+	waitForWaiters(t, &g, "k", 2)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled caller got %v, want context.Canceled", err)
+	}
+	select {
+	case <-callCancelled:
+		t.Fatal("call was cancelled while another caller still waited on it")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if val := <-second; val != "index" {
+		t.Errorf("waiting caller got %q, want index", val)
+	}
+}
+
+func TestFlightLastWaiterCancels(t *testing.T) {
+	var g flightGroup[string]
+	var calls atomic.Int32
+	callCancelled := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		if calls.Add(1) > 1 {
+			return "fresh", nil
+		}
+		<-ctx.Done()
+		close(callCancelled)
+		return "", ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := g.do(ctx, "k", fn); !errors.Is(err, context.Canceled) {
+				t.Errorf("got %v, want context.Canceled", err)
+			}
+		}()
+	}
+	waitForWaiters(t, &g, "k", 2)
+	cancel()
+	wg.Wait()
+
+	select {
+	case <-callCancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call kept running after every caller had gone")
+	}
+
+	// A caller arriving afterwards starts a new call instead of joining the dead one
+	val, shared, err := g.do(context.Background(), "k", fn)
+	if val != "fresh" || shared || err != nil {
+		t.Errorf("got %q shared %v %v, want a fresh unshared call", val, shared, err)
+	}
+}
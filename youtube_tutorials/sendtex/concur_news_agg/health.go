@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+var startedAt = time.Now()
+
+// healthzHandler only says the process is up and serving
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status": "ok",
+		"uptime": time.Since(startedAt).Round(time.Second).String(),
+	})
+}
+
+// readyReport is the body of /readyz
+type readyReport struct {
+	Status      string                  `json:"status"`
+	Checks      map[string]bool         `json:"checks"`
+	LastRefresh time.Time               `json:"last_refresh,omitzero"`
+	Articles    int                     `json:"articles"`
+	Sources     map[string]sourceStatus `json:"sources"`
+}
+
+// readyzHandler says if the load balancer should send traffic here:
+// an aggregation succeeded within --stale-after and at least one source
+// is still working. Templates aren't checked, the server won't start
+// without them and a failed --watch reload keeps the old ones
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	agg.mu.RLock()
+	report := readyReport{
+		LastRefresh: agg.refreshedAt,
+		Articles:    len(agg.newsMap),
+		Sources:     make(map[string]sourceStatus, len(agg.sources)),
+	}
+	allFailing := true
+	for location, s := range agg.sources {
+		report.Sources[location] = s
+		if !s.failing() {
+			allFailing = false
+		}
+	}
+	agg.mu.RUnlock()
+
+	report.Checks = map[string]bool{
+		"recent_aggregation": !report.LastRefresh.IsZero() && time.Since(report.LastRefresh) < *staleAfter,
+		"sources_healthy":    !allFailing,
+	}
+
+	report.Status = "ready"
+	code := http.StatusOK
+	for _, ok := range report.Checks {
+		if !ok {
+			report.Status = "not ready"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, code, report)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	// Probes want a fresh answer, handlers that can be cached set their own
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+var detectLanguage = flag.Bool("detect-language", true, "guess the language of articles whose sitemap doesn't declare one")
+
+var articleLanguages = web.NewCounter("newsagg_article_languages_total",
+	"Articles read by each refresh, by where their language came from: declared, detected or unknown.", "from")
+
+// undetermined is the ISO 639 code for articles we couldn't tell the language of,
+// ?lang=und picks them out
+const undetermined = "und"
+
+// Names shown in the language picker, other codes are shown as they are
+var languageNames = map[string]string{
+	"ar": "العربية", "de": "Deutsch", "el": "Ελληνικά", "en": "English", "es": "Español",
+	"fr": "Français", "he": "עברית", "hi": "हिन्दी", "it": "Italiano", "ja": "日本語",
+	"ko": "한국어", "nl": "Nederlands", "pl": "Polski", "pt": "Português", "ru": "Русский",
+	"sv": "Svenska", "th": "ไทย", "uk": "Українська", "zh": "中文", "zh-cn": "中文 (简体)",
+	"zh-tw": "中文 (繁體)", undetermined: "Unknown",
+}
+
+func languageName(code string) string {
+	if name, ok := languageNames[code]; ok {
+		return name
+	}
+	return code
+}
+
+// normalizeLanguage cleans up a declared news:language. The news sitemap spec
+// wants an ISO 639 code, or zh-cn / zh-tw, sites send region tags and odd case
+// too. Anything that doesn't look like a code comes back empty.
+func normalizeLanguage(code string) string {
+	code = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(code)), "_", "-")
+	if code == "zh-cn" || code == "zh-tw" {
+		return code
+	}
+	code, _, _ = strings.Cut(code, "-")
+	if len(code) < 2 || len(code) > 3 || code == undetermined {
+		return ""
+	}
+	for _, r := range code {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+	return code
+}
+
+// articleLanguage is the declared language if there is one, else a guess
+// from the title and keywords, else "und"
+func articleLanguage(declared, title, keywords string) string {
+	if code := normalizeLanguage(declared); code != "" {
+		articleLanguages.Inc("declared")
+		return code
+	}
+	if *detectLanguage {
+		if code := detectLanguageOf(title + " " + keywords); code != "" {
+			articleLanguages.Inc("detected")
+			return code
+		}
+	}
+	articleLanguages.Inc("unknown")
+	return undetermined
+}
+
+// Texts with fewer letters than this are too short to guess from
+const minDetectLetters = 12
+
+// A guess has to beat the runner up by this much log probability per
+// trigram, otherwise the text could be either and we say we don't know
+const minDetectMargin = 0.15
+
+// detectLanguageOf guesses the language of a short text. Scripts only used by
+// one language decide it straight away, Latin and Cyrillic text is scored
+// against the trigram profiles. Returns "" when it can't tell.
+func detectLanguageOf(text string) string {
+	scripts := map[string]int{}
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, s := range scriptOrder {
+			if unicode.In(r, scriptTables[s]...) {
+				scripts[s]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	// Kana is only Japanese even when most of the text is kanji
+	if scripts["kana"] > 0 {
+		return "ja"
+	}
+	script, most := "", 0
+	for _, s := range scriptOrder {
+		if scripts[s] > most {
+			script, most = s, scripts[s]
+		}
+	}
+	switch script {
+	case "latin", "cyrillic":
+		if letters < minDetectLetters {
+			return ""
+		}
+		return bestProfile(text, script)
+	case "":
+		return ""
+	default:
+		return scriptLanguages[script]
+	}
+}
+
+// Scripts checked in order, the first that has the letter counts it
+var scriptOrder = []string{"latin", "cyrillic", "greek", "arabic", "hebrew", "kana", "hangul", "han", "thai", "devanagari"}
+
+var scriptTables = map[string][]*unicode.RangeTable{
+	"latin":      {unicode.Latin},
+	"cyrillic":   {unicode.Cyrillic},
+	"greek":      {unicode.Greek},
+	"arabic":     {unicode.Arabic},
+	"hebrew":     {unicode.Hebrew},
+	"kana":       {unicode.Hiragana, unicode.Katakana},
+	"hangul":     {unicode.Hangul},
+	"han":        {unicode.Han},
+	"thai":       {unicode.Thai},
+	"devanagari": {unicode.Devanagari},
+}
+
+// The language a script almost always means in news sitemaps
+var scriptLanguages = map[string]string{
+	"greek":      "el",
+	"arabic":     "ar",
+	"hebrew":     "he",
+	"hangul":     "ko",
+	"han":        "zh",
+	"thai":       "th",
+	"devanagari": "hi",
+}
+
+// languageProfile holds the trigram counts of a language's sample text
+type languageProfile struct {
+	code    string
+	script  string
+	counts  map[string]int
+	total   int
+	unknown float64         // log probability of a trigram not in the sample
+	words   map[string]bool // its most common short words
+}
+
+var languageProfiles []*languageProfile
+
+func init() {
+	for code, sample := range languageSamples {
+		p := &languageProfile{code: code, script: "latin", counts: map[string]int{}, words: map[string]bool{}}
+		for _, w := range strings.Fields(languageWords[code]) {
+			p.words[w] = true
+		}
+		if code == "ru" || code == "uk" {
+			p.script = "cyrillic"
+		}
+		for _, g := range trigrams(sample) {
+			p.counts[g]++
+			p.total++
+		}
+		p.unknown = math.Log(1 / float64(p.total+len(p.counts)))
+		languageProfiles = append(languageProfiles, p)
+	}
+	// Map order would make ties come out differently run to run
+	sort.Slice(languageProfiles, func(i, j int) bool { return languageProfiles[i].code < languageProfiles[j].code })
+}
+
+// Extra log probability for each of a language's common words in the text.
+// Headlines are mostly names, the few little words left say the most.
+const commonWordBonus = 2.0
+
+// bestProfile scores text against the profiles for its script, naive Bayes
+// over letter trigrams with add one smoothing, plus the common word bonus
+func bestProfile(text, script string) string {
+	grams := trigrams(text)
+	if len(grams) == 0 {
+		return ""
+	}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && r != '\'' })
+	best, second := math.Inf(-1), math.Inf(-1)
+	code := ""
+	for _, p := range languageProfiles {
+		if p.script != script {
+			continue
+		}
+		score := 0.0
+		for _, g := range grams {
+			if n, ok := p.counts[g]; ok {
+				score += math.Log(float64(n+1) / float64(p.total+len(p.counts)))
+			} else {
+				score += p.unknown
+			}
+		}
+		for _, w := range words {
+			if p.words[w] {
+				score += commonWordBonus
+			}
+		}
+		switch {
+		case score > best:
+			best, second, code = score, best, p.code
+		case score > second:
+			second = score
+		}
+	}
+	if (best-second)/float64(len(grams)) < minDetectMargin {
+		return ""
+	}
+	return code
+}
+
+// trigrams are the three letter sequences of each lower cased word, with a
+// space either side so word starts and ends count too
+func trigrams(text string) []string {
+	var grams []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		r := []rune(" " + w + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			grams = append(grams, string(r[i:i+3]))
+		}
+	}
+	return grams
+}
+
+// Function words, pronouns and the like that headlines keep
+var languageWords = map[string]string{
+	"en": "the a an of to in on at for with from by and or but is are was were be has have had will would can could not no new after over as about how why what who says said its their his her this that than up out",
+	"de": "der die das den dem des ein eine einen einem und oder aber ist sind war hat haben wird werden nicht kein mit von für auf aus bei nach über vor im am zum zur wie warum was wer sich auch noch neue neuer gegen",
+	"fr": "le la les un une des du de et ou mais est sont était a ont sera pas ne avec pour sur dans par après avant comment pourquoi qui que au aux son sa ses leur cette ce nouveau nouvelle contre l' d' qu'",
+	"es": "el la los las un una unos unas del de y o pero es son era ha han será no con para por en sobre tras antes cómo por qué quién que al su sus este esta nuevo nueva contra se",
+	"it": "il lo la i gli le un una uno del della dei delle di e o ma è sono era ha hanno sarà non con per su nel nella tra dopo prima come perché chi che al alla suo sua nuovo nuova contro l' dell'",
+	"pt": "o a os as um uma uns umas do da dos das de e ou mas é são era tem têm será não com para por em no na nos nas sobre após antes como por que quem ao à seu sua novo nova contra se",
+	"nl": "de het een van en of maar is zijn was heeft hebben wordt worden niet geen met voor op uit bij na over in aan hoe waarom wat wie ook nog nieuwe tegen dat die",
+	"sv": "en ett den det de och eller men är var har hade blir inte med för på av från till efter över i om hur varför vad vem som också nya mot sig",
+	"pl": "i w z na do o od po za przez dla nie jest są był była będzie się jak dlaczego co kto że ten ta to nowy nowa przeciw oraz ale czy",
+	"ru": "и в во на с со к по о об от до за из у не это как что кто для при после перед новый новая против также но или уже",
+	"uk": "і й в у на з із до по про від за для не це як що хто при після перед новий нова проти також але або вже",
+}
+
+// Short news style samples the trigram profiles are built from. They're
+// small enough to keep in the binary and good enough for headlines.
+var languageSamples = map[string]string{
+	"en": `The government said on Tuesday that it would raise taxes to pay for the new hospital.
+Police are looking for a man after a shooting in the city centre. The storm hit the coast overnight
+and thousands of homes were left without power. Shares fell sharply as investors worried about
+the economy and rising interest rates. The president will meet world leaders at the summit this week.
+What we know about the election results and who is likely to win. Scientists have found that the
+climate is changing faster than expected. The team won the final with a late goal from their captain.`,
+	"de": `Die Regierung hat am Dienstag angekündigt, die Steuern für das neue Krankenhaus zu erhöhen.
+Die Polizei sucht nach einem Mann, nachdem in der Innenstadt Schüsse gefallen sind. Der Sturm traf in
+der Nacht die Küste und tausende Haushalte waren ohne Strom. Die Aktien fielen deutlich, weil Anleger
+sich über die Wirtschaft und steigende Zinsen sorgen. Der Präsident trifft in dieser Woche die
+Staatschefs beim Gipfel. Was wir über die Ergebnisse der Wahl wissen und wer gewinnen wird.
+Forscher haben herausgefunden, dass sich das Klima schneller verändert als erwartet. Die Mannschaft
+gewann das Finale mit einem späten Tor ihres Kapitäns.`,
+	"fr": `Le gouvernement a annoncé mardi qu'il allait augmenter les impôts pour financer le nouvel hôpital.
+La police recherche un homme après des tirs dans le centre de la ville. La tempête a frappé la côte
+pendant la nuit et des milliers de foyers sont privés d'électricité. Les actions ont fortement baissé
+car les investisseurs s'inquiètent de l'économie et de la hausse des taux. Le président rencontrera
+les dirigeants du monde au sommet cette semaine. Ce que l'on sait des résultats de l'élection et qui
+pourrait gagner. Des chercheurs ont découvert que le climat change plus vite que prévu. L'équipe a
+remporté la finale grâce à un but tardif de son capitaine.`,
+	"es": `El gobierno anunció el martes que subirá los impuestos para pagar el nuevo hospital.
+La policía busca a un hombre tras un tiroteo en el centro de la ciudad. La tormenta golpeó la costa
+durante la noche y miles de hogares se quedaron sin luz. Las acciones cayeron con fuerza porque los
+inversores están preocupados por la economía y la subida de los tipos de interés. El presidente se
+reunirá con los líderes mundiales en la cumbre esta semana. Lo que sabemos de los resultados de las
+elecciones y quién puede ganar. Los científicos han descubierto que el clima cambia más rápido de lo
+esperado. El equipo ganó la final con un gol de su capitán en los últimos minutos.`,
+	"it": `Il governo ha annunciato martedì che aumenterà le tasse per pagare il nuovo ospedale.
+La polizia cerca un uomo dopo una sparatoria nel centro della città. La tempesta ha colpito la costa
+durante la notte e migliaia di case sono rimaste senza corrente. Le azioni sono crollate perché gli
+investitori sono preoccupati per l'economia e per l'aumento dei tassi di interesse. Il presidente
+incontrerà i leader mondiali al vertice questa settimana. Cosa sappiamo dei risultati delle elezioni
+e chi potrebbe vincere. Gli scienziati hanno scoperto che il clima sta cambiando più in fretta del
+previsto. La squadra ha vinto la finale con un gol del suo capitano negli ultimi minuti.`,
+	"pt": `O governo anunciou na terça-feira que vai aumentar os impostos para pagar o novo hospital.
+A polícia procura um homem depois de um tiroteio no centro da cidade. A tempestade atingiu a costa
+durante a noite e milhares de casas ficaram sem energia. As ações caíram muito porque os investidores
+estão preocupados com a economia e com a subida dos juros. O presidente vai se reunir com os líderes
+mundiais na cúpula esta semana. O que sabemos sobre os resultados da eleição e quem pode ganhar.
+Os cientistas descobriram que o clima está mudando mais rápido do que o esperado. A equipe venceu a
+final com um gol do seu capitão nos últimos minutos.`,
+	"nl": `De regering heeft dinsdag aangekondigd dat de belastingen omhoog gaan om het nieuwe ziekenhuis
+te betalen. De politie zoekt een man na een schietpartij in het centrum van de stad. De storm trof
+vannacht de kust en duizenden huizen zaten zonder stroom. De aandelen daalden flink omdat beleggers
+zich zorgen maken over de economie en de stijgende rente. De president ontmoet deze week de
+wereldleiders op de top. Wat we weten over de uitslag van de verkiezingen en wie er kan winnen.
+Wetenschappers hebben ontdekt dat het klimaat sneller verandert dan verwacht. Het team won de finale
+met een late goal van de aanvoerder.`,
+	"sv": `Regeringen meddelade på tisdagen att skatterna ska höjas för att betala det nya sjukhuset.
+Polisen letar efter en man efter en skottlossning i centrala staden. Stormen drabbade kusten under
+natten och tusentals hushåll blev utan ström. Aktierna föll kraftigt när investerare oroade sig för
+ekonomin och stigande räntor. Presidenten ska träffa världens ledare på toppmötet i veckan. Det här
+vet vi om valresultatet och vem som kan vinna. Forskare har upptäckt att klimatet förändras snabbare
+än väntat. Laget vann finalen med ett sent mål av sin kapten.`,
+	"pl": `Rząd ogłosił we wtorek, że podniesie podatki, aby zapłacić za nowy szpital. Policja szuka
+mężczyzny po strzelaninie w centrum miasta. Burza uderzyła w wybrzeże w nocy i tysiące domów zostały
+bez prądu. Akcje mocno spadły, ponieważ inwestorzy martwią się o gospodarkę i rosnące stopy
+procentowe. Prezydent spotka się w tym tygodniu z przywódcami świata na szczycie. Co wiemy o wynikach
+wyborów i kto może wygrać. Naukowcy odkryli, że klimat zmienia się szybciej, niż się spodziewano.
+Drużyna wygrała finał dzięki późnemu golowi swojego kapitana.`,
+	"ru": `Правительство во вторник объявило, что повысит налоги, чтобы оплатить новую больницу.
+Полиция ищет мужчину после стрельбы в центре города. Ночью шторм обрушился на побережье, и тысячи
+домов остались без электричества. Акции резко упали, так как инвесторы обеспокоены состоянием
+экономики и ростом процентных ставок. На этой неделе президент встретится с мировыми лидерами на
+саммите. Что известно о результатах выборов и кто может победить. Учёные выяснили, что климат
+меняется быстрее, чем ожидалось. Команда выиграла финал благодаря позднему голу своего капитана.`,
+	"uk": `Уряд у вівторок оголосив, що підвищить податки, щоб оплатити нову лікарню. Поліція шукає
+чоловіка після стрілянини в центрі міста. Вночі шторм обрушився на узбережжя, і тисячі будинків
+залишилися без світла. Акції різко впали, оскільки інвестори стурбовані станом економіки та
+зростанням відсоткових ставок. Цього тижня президент зустрінеться зі світовими лідерами на саміті.
+Що відомо про результати виборів і хто може перемогти. Науковці з'ясували, що клімат змінюється
+швидше, ніж очікувалося. Команда виграла фінал завдяки пізньому голу свого капітана.`,
+}
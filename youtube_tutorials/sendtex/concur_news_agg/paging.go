@@ -0,0 +1,242 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rows shown on one page of the table, and the most a client may ask for
+const (
+	defaultPerPage = 25
+	maxPerPage     = 200
+)
+
+// NewsRow is one row of the rendered news table
+// Near duplicates of the article from other outlets are folded into Others
+type NewsRow struct {
+	ID       string
+	Title    string
+	Keyword  string
+	Location string
+	Language string
+	Summary  string
+	Others   []NewsRow
+}
+
+// Outlet is the site the article is on
+func (r NewsRow) Outlet() string {
+	if u, err := url.Parse(r.Location); err == nil && u.Host != "" {
+		return strings.TrimPrefix(u.Host, "www.")
+	}
+	return r.Location
+}
+
+// tableQuery holds the search, sort and paging options read from the URL
+type tableQuery struct {
+	Search  string
+	Sort    string // "title" or "keyword"
+	Order   string // "asc" or "desc"
+	Page    int
+	PerPage int
+	Lang    string // only articles in this language, "" for all
+	All     bool   // show articles hidden by the user's preferences
+}
+
+func parseTableQuery(v url.Values) tableQuery {
+	q := tableQuery{
+		Search:  strings.TrimSpace(v.Get("q")),
+		Sort:    "title",
+		Order:   "asc",
+		Page:    1,
+		PerPage: defaultPerPage,
+		Lang:    strings.ToLower(strings.TrimSpace(v.Get("lang"))),
+		All:     v.Get("all") == "1",
+	}
+	if v.Get("sort") == "keyword" {
+		q.Sort = "keyword"
+	}
+	if v.Get("order") == "desc" {
+		q.Order = "desc"
+	}
+	if n, err := strconv.Atoi(v.Get("page")); err == nil && n > 0 {
+		q.Page = n
+	}
+	if n, err := strconv.Atoi(v.Get("per")); err == nil && n > 0 {
+		q.PerPage = min(n, maxPerPage)
+	}
+	return q
+}
+
+// Encode the query back into a URL query string
+func (q tableQuery) encode() string {
+	v := url.Values{}
+	if q.Search != "" {
+		v.Set("q", q.Search)
+	}
+	v.Set("sort", q.Sort)
+	v.Set("order", q.Order)
+	if q.Lang != "" {
+		v.Set("lang", q.Lang)
+	}
+	v.Set("page", strconv.Itoa(q.Page))
+	if q.PerPage != defaultPerPage {
+		v.Set("per", strconv.Itoa(q.PerPage))
+	}
+	if q.All {
+		v.Set("all", "1")
+	}
+	return "?" + v.Encode()
+}
+
+// buildRows filters, sorts and pages the news map into table rows
+// Articles with the same key in clusters share the row of whichever sorts first
+// Returns the rows for the requested page, the number of matches and the number of pages
+func buildRows(newsMap map[string]NewsMap, q *tableQuery, clusters map[string]string) ([]NewsRow, int, int) {
+	search := strings.ToLower(q.Search)
+	rows := make([]NewsRow, 0, len(newsMap))
+	for title, data := range newsMap {
+		if search != "" &&
+			!strings.Contains(strings.ToLower(title), search) &&
+			!strings.Contains(strings.ToLower(data.Keyword), search) {
+			continue
+		}
+		if q.Lang != "" && data.Language != q.Lang {
+			continue
+		}
+		rows = append(rows, NewsRow{ID: data.ID, Title: title, Keyword: data.Keyword, Location: data.Location, Language: data.Language})
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i].Title, rows[j].Title
+		if q.Sort == "keyword" && rows[i].Keyword != rows[j].Keyword {
+			a, b = rows[i].Keyword, rows[j].Keyword
+		}
+		if q.Order == "desc" {
+			return a > b
+		}
+		return a < b
+	})
+
+	// Fold near duplicates into the first row of their story
+	if len(clusters) > 0 {
+		grouped := rows[:0]
+		head := map[string]int{}
+		for _, row := range rows {
+			key, ok := clusters[row.Title]
+			if !ok {
+				grouped = append(grouped, row)
+				continue
+			}
+			if i, seen := head[key]; seen {
+				grouped[i].Others = append(grouped[i].Others, row)
+				continue
+			}
+			head[key] = len(grouped)
+			grouped = append(grouped, row)
+		}
+		rows = grouped
+	}
+
+	// Clamp the page so an out of range page shows the last one
+	total := len(rows)
+	pages := max((total+q.PerPage-1)/q.PerPage, 1)
+	q.Page = min(q.Page, pages)
+
+	start := (q.Page - 1) * q.PerPage
+	end := min(start+q.PerPage, total)
+	return rows[start:end], total, pages
+}
+
+// languageOption is one entry of the /agg/ language picker
+type languageOption struct {
+	Code     string
+	Name     string
+	Count    int
+	Selected bool
+}
+
+// languageOptions lists the languages in newsMap, most articles first
+func (p NewsAggPage) languageOptions(newsMap map[string]NewsMap) []languageOption {
+	counts := languageCounts(newsMap)
+	opts := make([]languageOption, 0, len(counts))
+	for code, n := range counts {
+		opts = append(opts, languageOption{Code: code, Name: languageName(code), Count: n, Selected: code == p.Query.Lang})
+	}
+	sort.Slice(opts, func(i, j int) bool {
+		if opts[i].Count != opts[j].Count {
+			return opts[i].Count > opts[j].Count
+		}
+		return opts[i].Code < opts[j].Code
+	})
+	return opts
+}
+
+// languageCounts is how many articles there are in each language
+func languageCounts(newsMap map[string]NewsMap) map[string]int {
+	counts := map[string]int{}
+	for _, data := range newsMap {
+		counts[data.Language]++
+	}
+	return counts
+}
+
+// SortLink returns the link for a column header, flipping the order if already sorted on it
+func (p NewsAggPage) SortLink(col string) string {
+	q := p.Query
+	order := "asc"
+	if q.Sort == col && q.Order == "asc" {
+		order = "desc"
+	}
+	q.Sort, q.Order, q.Page = col, order, 1
+	return q.encode()
+}
+
+// SortMark shows which way a column is sorted
+func (p NewsAggPage) SortMark(col string) string {
+	if p.Query.Sort != col {
+		return ""
+	}
+	if p.Query.Order == "desc" {
+		return "▼"
+	}
+	return "▲"
+}
+
+// AriaSort is the aria-sort value for a column header
+func (p NewsAggPage) AriaSort(col string) string {
+	if p.Query.Sort != col {
+		return "none"
+	}
+	if p.Query.Order == "desc" {
+		return "descending"
+	}
+	return "ascending"
+}
+
+// PageLink returns the link to page n keeping the current search and sort
+func (p NewsAggPage) PageLink(n int) string {
+	q := p.Query
+	q.Page = n
+	return q.encode()
+}
+
+// ShowAllLink is this page again including what the user's preferences hide
+func (p NewsAggPage) ShowAllLink() string {
+	q := p.Query
+	q.All, q.Page = true, 1
+	return q.encode()
+}
+
+// HasPrev ...
+func (p NewsAggPage) HasPrev() bool { return p.Query.Page > 1 }
+
+// HasNext ...
+func (p NewsAggPage) HasNext() bool { return p.Query.Page < p.Pages }
+
+// PrevPage ...
+func (p NewsAggPage) PrevPage() int { return p.Query.Page - 1 }
+
+// NextPage ...
+func (p NewsAggPage) NextPage() int { return p.Query.Page + 1 }
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+var prefsFile = flag.String("prefs-file", "", "JSON file storing user preferences, they are only kept in memory when empty")
+
+// People without an API key or login are told apart by a cookie
+const (
+	prefsCookie     = "newsagg_profile"
+	prefsCookieAge  = 365 * 24 * time.Hour
+	maxProfiles     = 10000 // oldest cookie profiles are forgotten past this
+	maxPrefsEntries = 100   // per list
+	maxPrefsLength  = 200   // per entry
+)
+
+// userPrefs is what one person wants to see on /agg/ and /api/news
+type userPrefs struct {
+	SavedSearches []savedSearch `json:"saved_searches"`
+	MutedKeywords []string      `json:"muted_keywords"` // articles with any of these keywords are hidden
+	HiddenSources []string      `json:"hidden_sources"` // articles from sitemaps whose URL contains any of these are hidden
+	Updated       time.Time     `json:"updated,omitzero"`
+}
+
+// savedSearch is a named /agg/ search
+type savedSearch struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// Link is the /agg/ URL for the search
+func (s savedSearch) Link() string {
+	return "/agg/?q=" + url.QueryEscape(s.Query)
+}
+
+// normalize lower cases, trims and dedups the lists and keeps them a sane size
+func (p *userPrefs) normalize() {
+	p.MutedKeywords = normalizePrefsList(p.MutedKeywords)
+	p.HiddenSources = normalizePrefsList(p.HiddenSources)
+
+	searches := []savedSearch{}
+	seen := map[string]bool{}
+	for _, s := range p.SavedSearches {
+		s.Query = strings.TrimSpace(s.Query)
+		s.Name = strings.TrimSpace(s.Name)
+		if s.Query == "" || len(s.Query) > maxPrefsLength || seen[s.Query] {
+			continue
+		}
+		if s.Name == "" || len(s.Name) > maxPrefsLength {
+			s.Name = s.Query
+		}
+		seen[s.Query] = true
+		searches = append(searches, s)
+	}
+	p.SavedSearches = searches[:min(len(searches), maxPrefsEntries)]
+}
+
+func normalizePrefsList(list []string) []string {
+	out := []string{}
+	for _, t := range normalizeTerms(list) {
+		if len(t) <= maxPrefsLength && !slices.Contains(out, t) {
+			out = append(out, t)
+		}
+	}
+	return out[:min(len(out), maxPrefsEntries)]
+}
+
+// hides says if the article is muted or from a hidden source
+func (p userPrefs) hides(keyword, source string) bool {
+	source = strings.ToLower(source)
+	for _, s := range p.HiddenSources {
+		if strings.Contains(source, s) {
+			return true
+		}
+	}
+	for _, k := range splitKeywords(keyword) {
+		if slices.Contains(p.MutedKeywords, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the news without the articles the user hid, and how many that was
+func (p userPrefs) apply(newsMap map[string]NewsMap) (map[string]NewsMap, int) {
+	if len(p.MutedKeywords) == 0 && len(p.HiddenSources) == 0 {
+		return newsMap, 0
+	}
+	shown := make(map[string]NewsMap, len(newsMap))
+	for title, data := range newsMap {
+		if !p.hides(data.Keyword, data.Source) {
+			shown[title] = data
+		}
+	}
+	return shown, len(newsMap) - len(shown)
+}
+
+// matcher filters live events the same way, nil if nothing is hidden
+func (p userPrefs) matcher() func(NewsEvent) bool {
+	if len(p.MutedKeywords) == 0 && len(p.HiddenSources) == 0 {
+		return nil
+	}
+	return func(ev NewsEvent) bool { return !p.hides(ev.Keyword, ev.Source) }
+}
+
+// prefsStore keeps everyone's preferences, saved to --prefs-file on change
+type prefsStore struct {
+	mu       sync.Mutex
+	path     string
+	profiles map[string]userPrefs // by profile ID, see profileID
+	version  int                  // bumped on every change
+
+	// Saving happens outside mu so requests don't wait on the disk, writeMu
+	// keeps the writes in order and saved stops an older copy replacing a newer one
+	writeMu sync.Mutex
+	saved   int
+}
+
+var prefs = &prefsStore{profiles: make(map[string]userPrefs)}
+
+func loadPrefs(path string) (*prefsStore, error) {
+	s := &prefsStore{path: path, profiles: make(map[string]userPrefs)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.profiles); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *prefsStore) get(id string) userPrefs {
+	if id == "" {
+		return userPrefs{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.profiles[id]
+}
+
+// put replaces a profile's preferences and saves the store
+func (s *prefsStore) put(id string, p userPrefs) (userPrefs, error) {
+	p.normalize()
+	p.Updated = time.Now()
+
+	s.mu.Lock()
+	s.profiles[id] = p
+	s.prune()
+	s.version++
+	version := s.version
+	data, err := json.MarshalIndent(s.profiles, "", "  ")
+	s.mu.Unlock()
+	if err != nil || s.path == "" {
+		return p, err
+	}
+	return p, s.save(version, data)
+}
+
+// save writes out the store as of version, unless a later version is already on disk
+func (s *prefsStore) save(version int, data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if version <= s.saved {
+		return nil
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	s.saved = version
+	return nil
+}
+
+// prune forgets the least recently updated cookie profiles once there are
+// too many, profiles of API keys and users are always kept
+// Must be called with s.mu held
+func (s *prefsStore) prune() {
+	if len(s.profiles) <= maxProfiles {
+		return
+	}
+	var cookies []string
+	for id := range s.profiles {
+		if strings.HasPrefix(id, "cookie:") {
+			cookies = append(cookies, id)
+		}
+	}
+	sort.Slice(cookies, func(i, j int) bool {
+		return s.profiles[cookies[i]].Updated.Before(s.profiles[cookies[j]].Updated)
+	})
+	for _, id := range cookies[:min(len(cookies), len(s.profiles)-maxProfiles)] {
+		delete(s.profiles, id)
+	}
+}
+
+// profileID is whose preferences apply to the request: the API key or user
+// it authenticated as, else the profile cookie, else nobody
+func profileID(r *http.Request) string {
+	if p := web.PrincipalFrom(r.Context()); p != "" {
+		return p
+	}
+	if c, err := r.Cookie(prefsCookie); err == nil && validProfileCookie(c.Value) {
+		return "cookie:" + c.Value
+	}
+	return ""
+}
+
+// Cookies are 32 hex characters, anything else is ignored
+func validProfileCookie(v string) bool {
+	_, err := hex.DecodeString(v)
+	return len(v) == 32 && err == nil
+}
+
+// ensureProfileID is profileID but hands out a cookie if there's no profile yet
+// Only done when someone saves something, so visitors don't all get one
+func ensureProfileID(w http.ResponseWriter, r *http.Request) string {
+	if id := profileID(r); id != "" {
+		return id
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	value := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     prefsCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(prefsCookieAge.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return "cookie:" + value
+}
+
+// PrefsPage is the data for prefs.html
+type PrefsPage struct {
+	Title    string
+	Prefs    userPrefs
+	Muted    string // one keyword per line for the textarea
+	Sources  []sourceOption
+	Identity string
+	Saved    bool
+}
+
+// sourceOption is a checkbox on the preferences page
+type sourceOption struct {
+	URL    string
+	Hidden bool
+}
+
+// prefsHandler shows the preferences form at GET /prefs
+func prefsHandler(w http.ResponseWriter, r *http.Request) {
+	id := profileID(r)
+	p := prefs.get(id)
+
+	page := PrefsPage{
+		Title:    "Your news preferences",
+		Prefs:    p,
+		Muted:    strings.Join(p.MutedKeywords, "\n"),
+		Identity: "this browser",
+		Saved:    r.URL.Query().Get("saved") == "1",
+	}
+	if name, ok := strings.CutPrefix(id, "key:"); ok {
+		page.Identity = "API key " + name
+	} else if name, ok := strings.CutPrefix(id, "user:"); ok {
+		page.Identity = name
+	}
+
+	// Every source of the last aggregation plus any hidden one that has since gone
+	seen := map[string]bool{}
+	for _, data := range agg.snapshot() {
+		if key := strings.ToLower(data.Source); !seen[key] {
+			seen[key] = true
+			page.Sources = append(page.Sources, sourceOption{URL: data.Source, Hidden: p.hides("", data.Source)})
+		}
+	}
+	for _, s := range p.HiddenSources {
+		if !seen[s] {
+			page.Sources = append(page.Sources, sourceOption{URL: s, Hidden: true})
+		}
+	}
+	sort.Slice(page.Sources, func(i, j int) bool { return page.Sources[i].URL < page.Sources[j].URL })
+
+	w.Header().Set("Cache-Control", "private, no-store")
+	pages.Render(w, "prefs.html", page)
+}
+
+// savePrefsHandler takes the forms posted to /prefs:
+//
+//	action=save           muted (one per line or comma separated) and source (repeated)
+//	action=add-search     name and q, from the /agg/ page
+//	action=delete-search  q
+func savePrefsHandler(w http.ResponseWriter, r *http.Request) {
+	// The cookie is SameSite, this also stops other sites posting on behalf of an API key
+	if !sameOrigin(r) {
+		http.Error(w, "Cross-origin form not allowed", http.StatusForbidden)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad form", http.StatusBadRequest)
+		return
+	}
+
+	id := ensureProfileID(w, r)
+	p := prefs.get(id)
+	next := "/prefs?saved=1"
+	switch r.PostForm.Get("action") {
+	case "save":
+		p.MutedKeywords = strings.FieldsFunc(r.PostForm.Get("muted"), func(c rune) bool { return c == '\n' || c == ',' })
+		p.HiddenSources = r.PostForm["source"]
+	case "add-search":
+		q := r.PostForm.Get("q")
+		p.SavedSearches = append(p.SavedSearches, savedSearch{Name: r.PostForm.Get("name"), Query: q})
+		next = "/agg/?q=" + url.QueryEscape(strings.TrimSpace(q))
+	case "delete-search":
+		q := strings.TrimSpace(r.PostForm.Get("q"))
+		p.SavedSearches = slices.DeleteFunc(p.SavedSearches, func(s savedSearch) bool { return s.Query == q })
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := prefs.put(id, p); err != nil {
+		slog.Error("saving preferences failed", "err", err, "request_id", web.RequestIDFrom(r.Context()))
+		http.Error(w, "Could not save your preferences", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// prefsAPIHandler returns the caller's preferences as JSON at GET /api/prefs
+func prefsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	id := profileID(r)
+	if id == "" {
+		writeJSON(w, http.StatusOK, userPrefs{})
+		return
+	}
+	writeJSON(w, http.StatusOK, prefs.get(id))
+}
+
+// putPrefsAPIHandler replaces the caller's preferences at PUT /api/prefs
+func putPrefsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var p userPrefs
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bad preferences: " + err.Error()})
+		return
+	}
+	saved, err := prefs.put(ensureProfileID(w, r), p)
+	if err != nil {
+		slog.Error("saving preferences failed", "err", err, "request_id", web.RequestIDFrom(r.Context()))
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "could not save preferences"})
+		return
+	}
+	writeJSON(w, http.StatusOK, saved)
+}
+
+// userNews is the aggregated news as the requesting user wants to see it
+// Returns the news, how many articles their preferences hid and when the
+// result last changed, for the caching headers
+func userNews(r *http.Request, newsMap map[string]NewsMap, showAll bool) (map[string]NewsMap, int, time.Time) {
+	p := prefs.get(profileID(r))
+	modified := agg.lastModified()
+	if p.Updated.After(modified) {
+		modified = p.Updated
+	}
+	if showAll {
+		return newsMap, 0, modified
+	}
+	shown, hidden := p.apply(newsMap)
+	return shown, hidden, modified
+}
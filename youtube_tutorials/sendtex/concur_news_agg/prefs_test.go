@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestPrefsConcurrentPuts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prefs.json")
+	s, err := loadPrefs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := userPrefs{MutedKeywords: []string{fmt.Sprint("keyword", i)}}
+			if _, err := s.put(fmt.Sprint("key:", i%5), p); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Whatever order the writes ran in, the file has the last state
+	saved, err := loadPrefs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, p := range s.profiles {
+		if got := saved.get(id).MutedKeywords; len(got) != 1 || got[0] != p.MutedKeywords[0] {
+			t.Errorf("%s: saved %v, have %v", id, got, p.MutedKeywords)
+		}
+	}
+	if len(saved.profiles) != len(s.profiles) {
+		t.Errorf("saved %d profiles, have %d", len(saved.profiles), len(s.profiles))
+	}
+}
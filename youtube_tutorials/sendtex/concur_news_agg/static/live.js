@@ -0,0 +1,94 @@
+// Prepends newly aggregated articles to the news table as they arrive
+(function () {
+    "use strict";
+
+    var tbody = document.querySelector("#fancytable tbody");
+    if (!tbody || !window.EventSource) {
+        return;
+    }
+
+    // Only http and https links, a sitemap could list a javascript: URL
+    function safeHref(href) {
+        try {
+            var url = new URL(href, window.location.href);
+            if (url.protocol === "http:" || url.protocol === "https:") {
+                return url.href;
+            }
+        } catch (e) {
+            // not a URL at all
+        }
+        return null;
+    }
+
+    function link(href, text, attrs) {
+        var a = document.createElement("a");
+        a.href = href;
+        a.textContent = text;
+        for (var name in attrs) {
+            a.setAttribute(name, attrs[name]);
+        }
+        return a;
+    }
+
+    function row(article) {
+        var tr = document.createElement("tr");
+        tr.className = "new-article";
+
+        var title = document.createElement("td");
+        title.setAttribute("data-label", "Title");
+        var href = safeHref(article.location);
+        if (href) {
+            title.appendChild(link(href, article.title, {target: "_blank", rel: "noopener", lang: article.language}));
+        } else {
+            title.appendChild(document.createTextNode(article.title));
+        }
+        title.appendChild(document.createTextNode(" "));
+        title.appendChild(link("/news/" + article.id, "details", {
+            "class": "permalink",
+            "aria-label": "Details for " + article.title
+        }));
+
+        var keywords = document.createElement("td");
+        keywords.setAttribute("data-label", "Keywords");
+        keywords.textContent = article.keywords;
+
+        tr.appendChild(title);
+        tr.appendChild(keywords);
+        return tr;
+    }
+
+    var status = document.getElementById("live-status");
+    var params = new URLSearchParams(window.location.search);
+    // New articles only go on top of the first page of the unfiltered table,
+    // a search, a keyword sort or a later page would show them out of place
+    if ((params.get("q") || "").trim() !== "" || params.get("sort") === "keyword" ||
+            parseInt(params.get("page"), 10) > 1) {
+        if (status) {
+            status.textContent = "Live updates only show on the first page, without a search and sorted by title";
+        }
+        return;
+    }
+    // A page opened with ?all=1 also gets the articles the user's preferences hide
+    var all = params.get("all") === "1";
+    // and one filtered to a language only gets articles in it
+    var lang = params.get("lang");
+    var events = new EventSource(all ? "/events?all=1" : "/events");
+
+    events.addEventListener("article", function (e) {
+        var article = JSON.parse(e.data);
+        if (lang && article.language !== lang) {
+            return;
+        }
+        tbody.insertBefore(row(article), tbody.firstChild);
+    });
+    events.onopen = function () {
+        if (status) {
+            status.textContent = "Live updates on";
+        }
+    };
+    events.onerror = function () {
+        if (status) {
+            status.textContent = "Live updates reconnecting";
+        }
+    };
+})();
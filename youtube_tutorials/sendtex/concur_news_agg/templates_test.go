@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go-learning/youtube_tutorials/sendtex/internal/htmltest"
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+var sampleNews = map[string]NewsMap{
+	"Senate passes the budget bill": {
+		Keyword: "politics, budget", Location: "https://news.example.com/politics/budget", ID: "a1",
+		Source: "https://news.example.com/sitemap-a.xml", Language: "en", Published: time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC),
+	},
+	"Budget bill passes the Senate": {
+		Keyword: "politics, budget", Location: "https://other.example.org/budget", ID: "b2",
+		Source: "https://other.example.org/sitemap.xml", Language: "en",
+	},
+	"Le budget adopté <enfin> & vite": {
+		Keyword: "politique", Location: "https://news.example.com/fr/budget?x=1&y=2", ID: "c3",
+		Source: "https://news.example.com/sitemap-b.xml", Language: "fr",
+	},
+}
+
+// samplePages is every page with data close to what the handlers pass,
+// including the empty /agg/ page before the first refresh
+func samplePages() map[string][]any {
+	q := parseTableQuery(url.Values{"q": {"budget"}, "sort": {"keyword"}})
+	full := NewsAggPage{
+		Title: "News",
+		Query: q,
+		Saved: []savedSearch{{Name: "Budget", Query: "budget"}},
+	}
+	full.Languages = full.languageOptions(sampleNews)
+	clusters := map[string]string{
+		"Senate passes the budget bill": "Budget bill passes the Senate",
+		"Budget bill passes the Senate": "Budget bill passes the Senate",
+	}
+	full.Rows, full.Total, full.Pages = buildRows(sampleNews, &full.Query, clusters)
+	full.Rows[0].Summary = "The Senate passed it."
+
+	empty := NewsAggPage{Title: "News", Query: parseTableQuery(nil)}
+	empty.Rows, empty.Total, empty.Pages = buildRows(nil, &empty.Query, nil)
+
+	title := "Senate passes the budget bill"
+	return map[string][]any{
+		"newsaggtemplate.html": {full, empty},
+		"news.html": {
+			NewsArticlePage{
+				Title:    title,
+				Article:  sampleNews[title],
+				Keywords: splitKeywords(sampleNews[title].Keyword),
+				Summary:  "The Senate passed it.",
+				Related:  relatedArticles(sampleNews, title, maxRelated),
+			},
+			NewsArticlePage{Title: "Bare", Article: NewsMap{ID: "x", Language: undetermined}, Dropped: time.Now()},
+		},
+		"prefs.html": {
+			PrefsPage{
+				Title:    "Your news preferences",
+				Prefs:    userPrefs{SavedSearches: []savedSearch{{Name: "Budget", Query: "budget"}}, MutedKeywords: []string{"sport"}},
+				Muted:    "sport",
+				Sources:  []sourceOption{{URL: "https://news.example.com/sitemap-a.xml", Hidden: true}},
+				Identity: "this browser",
+				Saved:    true,
+			},
+			PrefsPage{Title: "Your news preferences"},
+		},
+		"error.html": {
+			web.ErrorPage{Title: "Not Found", Status: 404, Message: "No page here.", Path: "/nope"},
+		},
+	}
+}
+
+func TestTemplatesRender(t *testing.T) {
+	ts, err := loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for page, datas := range samplePages() {
+		for i, data := range datas {
+			var buf bytes.Buffer
+			if err := ts.Execute(&buf, page, data); err != nil {
+				t.Errorf("%s #%d: %v", page, i, err)
+				continue
+			}
+			if !strings.Contains(buf.String(), "</html>") {
+				t.Errorf("%s #%d: rendered page isn't a whole document", page, i)
+			}
+		}
+	}
+}
+
+func TestAggPageBalanced(t *testing.T) {
+	ts, err := loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range samplePages()["newsaggtemplate.html"] {
+		var buf bytes.Buffer
+		if err := ts.Execute(&buf, "newsaggtemplate.html", data); err != nil {
+			t.Fatal(err)
+		}
+		if err := htmltest.CheckBalanced(buf.String()); err != nil {
+			t.Errorf("newsaggtemplate.html #%d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Just enough of RFC 6455 to push JSON messages to browsers and read
+// small JSON messages back, there's no WebSocket package in the standard library
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Frame opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// Close status codes
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsClosePolicy        = 1008
+	wsCloseTooBig        = 1009
+	wsCloseTryAgainLater = 1013
+)
+
+// Clients only send subscriptions, anything bigger is refused
+const wsMaxMessage = 64 << 10
+
+var errWSClosed = errors.New("websocket closed")
+
+// wsConn is a server side WebSocket connection
+// Reads must come from one goroutine, writes are safe from any
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	writeMu sync.Mutex
+}
+
+// wsUpgrade checks the handshake and takes over the connection from net/http
+// Only same-origin browsers (or non-browser clients) are accepted
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("not a websocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "Bad Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("bad websocket key")
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "Cross-origin WebSocket not allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("websocket origin %q not allowed", r.Header.Get("Origin"))
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSocket not supported on this connection", http.StatusInternalServerError)
+		return nil, err
+	}
+	// The server's read and write timeouts would otherwise cut the socket off
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", accept)
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+// sameOrigin is false if the browser says the request came from another site
+// Clients that aren't browsers don't send Origin and are let through
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readMessage returns the next text or binary message, answering pings
+// and putting fragmented messages back together
+func (c *wsConn) readMessage() (int, []byte, error) {
+	var msg []byte
+	msgType := -1
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			// Echo the close back, the connection is done after this
+			c.writeFrame(wsClose, payload)
+			return 0, nil, errWSClosed
+		case wsText, wsBinary:
+			if msgType != -1 {
+				return 0, nil, c.fail(wsCloseProtocolError, "expected continuation frame")
+			}
+			msgType = opcode
+		case wsContinuation:
+			if msgType == -1 {
+				return 0, nil, c.fail(wsCloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(wsCloseProtocolError, "unknown opcode")
+		}
+
+		if len(msg)+len(payload) > wsMaxMessage {
+			return 0, nil, c.fail(wsCloseTooBig, "message too big")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msgType, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = int(head[0] & 0x0F)
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+
+	if head[0]&0x70 != 0 {
+		err = c.fail(wsCloseProtocolError, "reserved bits set")
+		return
+	}
+	// Browsers always mask what they send
+	if !masked {
+		err = c.fail(wsCloseProtocolError, "client frames must be masked")
+		return
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= wsClose && (length > 125 || !fin) {
+		err = c.fail(wsCloseProtocolError, "bad control frame")
+		return
+	}
+	if length > wsMaxMessage {
+		err = c.fail(wsCloseTooBig, "message too big")
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// writeFrame sends one unmasked frame, servers never mask
+func (c *wsConn) writeFrame(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	frame := []byte{0x80 | byte(opcode)}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *wsConn) writeText(data []byte) error {
+	return c.writeFrame(wsText, data)
+}
+
+// closeWith sends a close frame with a status code and reason and hangs up
+func (c *wsConn) closeWith(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	c.writeFrame(wsClose, payload)
+	return c.conn.Close()
+}
+
+// fail closes the connection because the client broke the protocol
+func (c *wsConn) fail(code int, reason string) error {
+	c.closeWith(code, reason)
+	return fmt.Errorf("websocket: %s", reason)
+}
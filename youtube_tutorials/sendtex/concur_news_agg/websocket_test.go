@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// wsPair connects a server side wsConn to a raw client connection
+func wsPair(t *testing.T) (*wsConn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return &wsConn{conn: server, br: bufio.NewReader(server)}, client
+}
+
+// clientFrame builds a frame the way a browser would, masked unless told not to
+// Lengths past 125 use the 16 or 64 bit extended length
+func clientFrame(fin bool, opcode int, payload []byte, masked bool) []byte {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if !masked {
+		return append(frame, payload...)
+	}
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask[:]...)
+	for i, c := range payload {
+		frame = append(frame, c^mask[i%4])
+	}
+	return frame
+}
+
+// readServerFrame reads one unmasked frame from the server
+func readServerFrame(t *testing.T, r io.Reader) (int, []byte) {
+	t.Helper()
+	read := func(b []byte) {
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatalf("reading server frame: %v", err)
+		}
+	}
+	var head [2]byte
+	read(head[:])
+	if head[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		read(ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		read(ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, n)
+	read(payload)
+	return int(head[0] & 0x0F), payload
+}
+
+func TestWSUpgrade(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ws, err := wsUpgrade(w, r); err == nil {
+			ws.closeWith(wsCloseNormal, "")
+		}
+	}))
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		status int
+	}{
+		{"no origin", nil, http.StatusSwitchingProtocols},
+		{"same origin", map[string]string{"Origin": "http://" + host}, http.StatusSwitchingProtocols},
+		{"other origin", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"old version", map[string]string{"Sec-WebSocket-Version": "8"}, http.StatusUpgradeRequired},
+		{"short key", map[string]string{"Sec-WebSocket-Key": "c2hvcnQ="}, http.StatusBadRequest},
+		{"not an upgrade", map[string]string{"Upgrade": "h2c"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/ws", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		// The sample key from RFC 6455
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.status)
+			continue
+		}
+		switch resp.StatusCode {
+		case http.StatusSwitchingProtocols:
+			if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+				t.Errorf("%s: accept key %q, want the RFC's", tt.name, got)
+			}
+		case http.StatusUpgradeRequired:
+			if got := resp.Header.Get("Sec-WebSocket-Version"); got != "13" {
+				t.Errorf("%s: advertised version %q, want 13", tt.name, got)
+			}
+		}
+	}
+}
+
+func TestWSReadMessage(t *testing.T) {
+	big := bytes.Repeat([]byte("a"), 300)
+	huge := bytes.Repeat([]byte("b"), wsMaxMessage) // past 0xFFFF so 64 bit length
+	half := bytes.Repeat([]byte("c"), wsMaxMessage/2+1)
+
+	tests := []struct {
+		name   string
+		frames [][]byte
+		msg    []byte // wanted message, nil if the read fails
+		close  int    // close code the server sends, 0 for none
+		pong   bool   // a pong comes before the close
+	}{
+		{name: "masked text",
+			frames: [][]byte{clientFrame(true, wsText, []byte("hello"), true)},
+			msg:    []byte("hello")},
+		{name: "16 bit length",
+			frames: [][]byte{clientFrame(true, wsText, big, true)},
+			msg:    big},
+		{name: "64 bit length",
+			frames: [][]byte{clientFrame(true, wsBinary, huge, true)},
+			msg:    huge},
+		{name: "fragments with a ping between",
+			frames: [][]byte{
+				clientFrame(false, wsText, []byte("hel"), true),
+				clientFrame(true, wsPing, []byte("p"), true),
+				clientFrame(false, wsContinuation, []byte("l"), true),
+				clientFrame(true, wsContinuation, []byte("o"), true),
+			},
+			msg:  []byte("hello"),
+			pong: true},
+		{name: "unmasked",
+			frames: [][]byte{clientFrame(true, wsText, []byte("hello"), false)},
+			close:  wsCloseProtocolError},
+		{name: "reserved bits",
+			frames: [][]byte{append([]byte{0xC1}, clientFrame(true, wsText, nil, true)[1:]...)},
+			close:  wsCloseProtocolError},
+		{name: "continuation first",
+			frames: [][]byte{clientFrame(true, wsContinuation, []byte("lo"), true)},
+			close:  wsCloseProtocolError},
+		{name: "new message inside a fragmented one",
+			frames: [][]byte{
+				clientFrame(false, wsText, []byte("hel"), true),
+				clientFrame(true, wsText, []byte("lo"), true),
+			},
+			close: wsCloseProtocolError},
+		{name: "unknown opcode",
+			frames: [][]byte{clientFrame(true, 0x3, []byte("x"), true)},
+			close:  wsCloseProtocolError},
+		{name: "control frame over 125 bytes",
+			frames: [][]byte{clientFrame(true, wsPing, bytes.Repeat([]byte("p"), 126), true)},
+			close:  wsCloseProtocolError},
+		{name: "fragmented control frame",
+			frames: [][]byte{clientFrame(false, wsPing, []byte("p"), true)},
+			close:  wsCloseProtocolError},
+		{name: "frame over the limit",
+			frames: [][]byte{clientFrame(true, wsBinary, append(huge, 'x'), true)},
+			close:  wsCloseTooBig},
+		{name: "fragments over the limit together",
+			frames: [][]byte{
+				clientFrame(false, wsText, half, true),
+				clientFrame(true, wsContinuation, half, true),
+			},
+			close: wsCloseTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, client := wsPair(t)
+			go func() {
+				for _, f := range tt.frames {
+					if _, err := client.Write(f); err != nil {
+						return
+					}
+				}
+			}()
+
+			_, msg, err := ws.readMessage()
+			if tt.msg != nil {
+				if err != nil || !bytes.Equal(msg, tt.msg) {
+					t.Fatalf("got %d bytes, %v, want %d bytes", len(msg), err, len(tt.msg))
+				}
+			} else if err == nil {
+				t.Fatalf("read a %d byte message, want an error", len(msg))
+			}
+
+			r := bufio.NewReader(client)
+			if tt.pong {
+				if op, payload := readServerFrame(t, r); op != wsPong || string(payload) != "p" {
+					t.Errorf("got opcode %d %q, want the ping's pong", op, payload)
+				}
+			}
+			if tt.close != 0 {
+				op, payload := readServerFrame(t, r)
+				if op != wsClose || len(payload) < 2 {
+					t.Fatalf("got opcode %d, want a close frame", op)
+				}
+				if code := int(binary.BigEndian.Uint16(payload)); code != tt.close {
+					t.Errorf("close code %d, want %d", code, tt.close)
+				}
+			}
+		})
+	}
+}
+
+func TestWSCloseEchoed(t *testing.T) {
+	ws, client := wsPair(t)
+	payload := binary.BigEndian.AppendUint16(nil, wsCloseNormal)
+	client.Write(clientFrame(true, wsClose, payload, true))
+
+	if _, _, err := ws.readMessage(); !errors.Is(err, errWSClosed) {
+		t.Fatalf("got %v, want errWSClosed", err)
+	}
+	if op, echoed := readServerFrame(t, bufio.NewReader(client)); op != wsClose || !bytes.Equal(echoed, payload) {
+		t.Errorf("got opcode %d %v, want the close echoed", op, echoed)
+	}
+}
+
+func TestSubscriptionDropPolicies(t *testing.T) {
+	events := []NewsEvent{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	tests := []struct {
+		policy dropPolicy
+		want   []string // IDs left in the buffer
+		closed bool
+	}{
+		{dropNewest, []string{"1", "2"}, false},
+		{dropOldest, []string{"2", "3"}, false},
+		{dropDisconnect, []string{"1", "2"}, true},
+	}
+	for _, tt := range tests {
+		b := &newsBroker{subs: make(map[*subscription]struct{})}
+		sub := b.subscribe(2, tt.policy)
+		b.publish(events)
+
+		var got []string
+		closed := false
+	drain:
+		for {
+			select {
+			case ev, ok := <-sub.C:
+				if !ok {
+					closed = true
+					break drain
+				}
+				got = append(got, ev.ID)
+			default:
+				break drain
+			}
+		}
+		if len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] {
+			t.Errorf("policy %d: buffered %v, want %v", tt.policy, got, tt.want)
+		}
+		if closed != tt.closed {
+			t.Errorf("policy %d: closed %v, want %v", tt.policy, closed, tt.closed)
+		}
+		if n := sub.droppedCount(); n != 1 {
+			t.Errorf("policy %d: dropped %d, want 1", tt.policy, n)
+		}
+		b.unsubscribe(sub)
+	}
+
+	// Filtered out events don't count as dropped
+	b := &newsBroker{subs: make(map[*subscription]struct{})}
+	sub := b.subscribe(1, dropNewest)
+	sub.setFilter(func(ev NewsEvent) bool { return ev.ID == "1" })
+	b.publish(events)
+	if n := sub.droppedCount(); n != 0 || len(sub.C) != 1 {
+		t.Errorf("filter: dropped %d, buffered %d, want 0 and 1", n, len(sub.C))
+	}
+}
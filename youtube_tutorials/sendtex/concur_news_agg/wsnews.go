@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+// Articles buffered per WebSocket client
+var wsBuffer = flag.Int("ws-buffer", 32, "articles buffered per /ws client before the drop policy applies")
+
+// How often idle clients are pinged
+const wsPingInterval = 30 * time.Second
+
+// wsSubscribeMsg is what a client sends to choose its articles, e.g.
+// {"type":"subscribe","keywords":["election"],"sources":["politics"]}
+// Keywords match article keywords exactly, sources match part of the sitemap URL
+// Empty lists don't filter, so an empty subscription gets every article
+type wsSubscribeMsg struct {
+	Type     string   `json:"type"`
+	Keywords []string `json:"keywords"`
+	Sources  []string `json:"sources"`
+}
+
+// wsMessage is everything the server sends, told apart by Type:
+// "subscribed", "article", "dropped" or "error"
+type wsMessage struct {
+	Type     string     `json:"type"`
+	Article  *NewsEvent `json:"article,omitempty"`
+	Keywords []string   `json:"keywords,omitempty"`
+	Sources  []string   `json:"sources,omitempty"`
+	Dropped  uint64     `json:"dropped,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+func (c *wsConn) writeJSON(msg wsMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.writeText(data)
+}
+
+var dropPolicies = map[string]dropPolicy{
+	"":           dropOldest,
+	"oldest":     dropOldest,
+	"newest":     dropNewest,
+	"disconnect": dropDisconnect,
+}
+
+// Nothing is sent until the client subscribes
+func matchNothing(NewsEvent) bool { return false }
+
+// newsFilter matches articles with any of the keywords from any of the sources
+func newsFilter(keywords, sources []string) func(NewsEvent) bool {
+	want := make(map[string]bool, len(keywords))
+	for _, k := range keywords {
+		want[k] = true
+	}
+	return func(ev NewsEvent) bool {
+		if len(sources) > 0 {
+			found := false
+			for _, s := range sources {
+				if strings.Contains(strings.ToLower(ev.Source), s) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		if len(want) == 0 {
+			return true
+		}
+		for _, k := range splitKeywords(ev.Keyword) {
+			if want[k] {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Lower case and drop blanks so they compare like splitKeywords output
+func normalizeTerms(terms []string) []string {
+	var out []string
+	for _, t := range terms {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// wsNewsHandler sends newly aggregated articles matching the client's
+// subscription over a WebSocket
+// ?drop=oldest|newest|disconnect picks what happens when the client falls
+// behind, the default drops the oldest buffered article
+func wsNewsHandler(w http.ResponseWriter, r *http.Request) {
+	policy, ok := dropPolicies[r.URL.Query().Get("drop")]
+	if !ok {
+		http.Error(w, "drop must be oldest, newest or disconnect", http.StatusBadRequest)
+		return
+	}
+	ws, err := wsUpgrade(w, r)
+	if err != nil {
+		slog.Warn("websocket upgrade failed", "err", err, "request_id", web.RequestIDFrom(r.Context()))
+		return
+	}
+	defer ws.conn.Close()
+
+	sub := broker.subscribe(*wsBuffer, policy)
+	sub.setFilter(matchNothing)
+	defer broker.unsubscribe(sub)
+
+	// Subscription messages are read on their own goroutine
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			_, data, err := ws.readMessage()
+			if err != nil {
+				return
+			}
+			var msg wsSubscribeMsg
+			if err := json.Unmarshal(data, &msg); err != nil || msg.Type != "subscribe" {
+				ws.writeJSON(wsMessage{Type: "error", Error: `expected {"type":"subscribe","keywords":[...],"sources":[...]}`})
+				continue
+			}
+			keywords, sources := normalizeTerms(msg.Keywords), normalizeTerms(msg.Sources)
+			sub.setFilter(newsFilter(keywords, sources))
+			ws.writeJSON(wsMessage{Type: "subscribed", Keywords: keywords, Sources: sources})
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	var reported uint64
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case <-ping.C:
+			err = ws.writeFrame(wsPing, nil)
+		case ev, ok := <-sub.C:
+			if !ok {
+				// Dropped by the broker under the disconnect policy
+				ws.closeWith(wsCloseTryAgainLater, "too slow, reconnect")
+				return
+			}
+			// Let the client know it missed some before the next article
+			if dropped := sub.droppedCount(); dropped != reported {
+				reported = dropped
+				if err = ws.writeJSON(wsMessage{Type: "dropped", Dropped: dropped}); err != nil {
+					break
+				}
+			}
+			err = ws.writeJSON(wsMessage{Type: "article", Article: &ev})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 )
@@ -49,7 +50,7 @@ func cleanup() {
 		// Recover from panic caused in say function
 		// Note that cleanup function call MUST be added in function where panic is to be caught
 		// Similar to try, except and catch
-		fmt.Println("Recovered in cleanup func, panic reported:", r)
+		slog.Warn("recovered in cleanup func", "panic", r)
 	}
 }
 
@@ -0,0 +1,5 @@
+module go-learning/youtube_tutorials/sendtex
+
+go 1.24.0
+
+require golang.org/x/crypto v0.48.0
@@ -0,0 +1,44 @@
+// Package htmltest has checks on rendered pages for the binaries' template tests
+package htmltest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Elements that never have an end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+var (
+	htmlSkip = regexp.MustCompile(`(?is)<!--.*?-->|<!doctype[^>]*>|<(script|style)\b[^>]*>.*?</(script|style)>`)
+	htmlTag  = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)(?:\s[^>]*)?>`)
+)
+
+// CheckBalanced makes sure every element that's opened is closed, in order
+// There's no HTML parser in the standard library and encoding/xml chokes on
+// void elements, so this only looks at the tags
+func CheckBalanced(doc string) error {
+	var open []string
+	for _, m := range htmlTag.FindAllStringSubmatch(htmlSkip.ReplaceAllString(doc, ""), -1) {
+		name := strings.ToLower(m[2])
+		switch {
+		case voidElements[name]:
+		case m[1] == "":
+			open = append(open, name)
+		case len(open) == 0:
+			return fmt.Errorf("</%s> with nothing open", name)
+		case open[len(open)-1] != name:
+			return fmt.Errorf("</%s> closes <%s>, open: %s", name, open[len(open)-1], strings.Join(open, " > "))
+		default:
+			open = open[:len(open)-1]
+		}
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("never closed: %s", strings.Join(open, " > "))
+	}
+	return nil
+}
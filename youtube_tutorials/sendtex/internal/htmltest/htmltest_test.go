@@ -0,0 +1,23 @@
+package htmltest
+
+import "testing"
+
+func TestCheckBalanced(t *testing.T) {
+	tests := []struct {
+		doc string
+		ok  bool
+	}{
+		{`<!DOCTYPE html><html><body><p>Hi<br><img src="a.png"></p></body></html>`, true},
+		{`<DIV><p class="x">a</P></div>`, true},
+		{`<div><!-- <span> --><script>if (a < b) { x = "<div>" }</script></div>`, true},
+		{`<div><span></div></span>`, false},
+		{`<div><p>never closed</div>`, false},
+		{`</p>`, false},
+		{`<table><tr><td>a</td></tr>`, false},
+	}
+	for _, tt := range tests {
+		if err := CheckBalanced(tt.doc); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v, want ok %v", tt.doc, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	apiKeysFile   = flag.String("api-keys", "", "file of name:key lines, one API key per line")
+	basicAuthFile = flag.String("basic-auth", "", "htpasswd style file of user:bcrypt-hash lines (htpasswd -B)")
+	authRules     = flag.String("auth", "", "comma separated path-prefix=policy rules, policy is public, key, basic or any (e.g. /api/=key), everything is public by default")
+	HashPassword  = flag.Bool("hash-password", false, "read a password from stdin, print its bcrypt hash for --basic-auth and exit")
+)
+
+// authPolicy is what a route needs before the handler runs
+type authPolicy string
+
+const (
+	policyPublic authPolicy = "public"
+	policyKey    authPolicy = "key"   // an API key
+	policyBasic  authPolicy = "basic" // a user from the basic auth file
+	policyAny    authPolicy = "any"   // either of the two
+)
+
+type authRule struct {
+	prefix string
+	policy authPolicy
+}
+
+// Authenticator checks requests against the route policies
+// Paths match the longest rule prefix, anything unmatched is public
+type Authenticator struct {
+	rules []authRule
+	keys  map[string]string // sha256 of key to key name
+	users map[string]string // user to bcrypt hash
+	dummy []byte            // checked for unknown users so they take as long as known ones
+
+	// Passwords that already passed bcrypt, so each request doesn't pay for it again
+	mu       sync.Mutex
+	verified map[string][32]byte
+}
+
+type principalKey struct{}
+
+// PrincipalFrom returns who made the request, "key:<name>" or "user:<name>",
+// or "" if the route was public and no credentials were sent
+func PrincipalFrom(ctx context.Context) string {
+	p, _ := ctx.Value(principalKey{}).(string)
+	return p
+}
+
+// LoadAuthenticator reads the --auth rules and the --api-keys and --basic-auth files
+func LoadAuthenticator() (*Authenticator, error) {
+	return loadAuthenticator(*authRules, *apiKeysFile, *basicAuthFile)
+}
+
+func loadAuthenticator(rules, keysPath, usersPath string) (*Authenticator, error) {
+	a := &Authenticator{
+		keys:     make(map[string]string),
+		users:    make(map[string]string),
+		verified: make(map[string][32]byte),
+	}
+
+	for _, rule := range strings.Split(rules, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		prefix, policy, ok := strings.Cut(rule, "=")
+		p := authPolicy(strings.TrimSpace(policy))
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("auth rule %q: want /path-prefix=policy", rule)
+		}
+		switch p {
+		case policyPublic, policyKey, policyBasic, policyAny:
+		default:
+			return nil, fmt.Errorf("auth rule %q: policy must be public, key, basic or any", rule)
+		}
+		a.rules = append(a.rules, authRule{prefix: strings.TrimSpace(prefix), policy: p})
+	}
+	// Longest prefix first so the most specific rule wins
+	sort.Slice(a.rules, func(i, j int) bool { return len(a.rules[i].prefix) > len(a.rules[j].prefix) })
+
+	if keysPath != "" {
+		err := readCredentials(keysPath, func(name, key string) {
+			a.keys[hashKey(key)] = name
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if usersPath != "" {
+		err := readCredentials(usersPath, func(user, hash string) {
+			a.users[user] = hash
+		})
+		if err != nil {
+			return nil, err
+		}
+		for user, hash := range a.users {
+			if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+				return nil, fmt.Errorf("%s: user %s: %w", usersPath, user, err)
+			}
+		}
+		if a.dummy, err = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost); err != nil {
+			return nil, err
+		}
+	}
+
+	// A rule nobody can pass would lock the route, better to refuse to start
+	for _, rule := range a.rules {
+		var missing string
+		switch {
+		case rule.policy == policyKey && len(a.keys) == 0:
+			missing = "--api-keys"
+		case rule.policy == policyBasic && len(a.users) == 0:
+			missing = "--basic-auth"
+		case rule.policy == policyAny && len(a.keys) == 0 && len(a.users) == 0:
+			missing = "--api-keys or --basic-auth"
+		}
+		if missing != "" {
+			return nil, fmt.Errorf("auth rule %s=%s: no credentials loaded, set %s", rule.prefix, rule.policy, missing)
+		}
+	}
+	return a, nil
+}
+
+// readCredentials reads name:secret lines, skipping blanks and # comments
+func readCredentials(path string, add func(name, secret string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, secret, ok := strings.Cut(line, ":")
+		if !ok || name == "" || secret == "" {
+			return fmt.Errorf("%s:%d: want name:secret", path, n)
+		}
+		add(name, secret)
+	}
+	return sc.Err()
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return string(sum[:])
+}
+
+func (a *Authenticator) policyFor(path string) authPolicy {
+	for _, rule := range a.rules {
+		if strings.HasPrefix(path, rule.prefix) {
+			return rule.policy
+		}
+	}
+	return policyPublic
+}
+
+// apiKey reads the key from X-API-Key, a bearer token or ?api_key=
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// checkKey looks the key up by its hash so the compare doesn't leak timing
+func (a *Authenticator) checkKey(r *http.Request) (string, bool) {
+	key := apiKey(r)
+	if key == "" {
+		return "", false
+	}
+	name, ok := a.keys[hashKey(key)]
+	return "key:" + name, ok
+}
+
+func (a *Authenticator) checkBasic(r *http.Request) (string, bool) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	hash, known := a.users[user]
+	if !known {
+		// Same amount of work as a known user so names can't be probed by timing
+		bcrypt.CompareHashAndPassword(a.dummy, []byte(password))
+		return "", false
+	}
+
+	sum := sha256.Sum256([]byte(hash + "\x00" + password))
+	a.mu.Lock()
+	cached, hit := a.verified[user]
+	a.mu.Unlock()
+	if hit && subtle.ConstantTimeCompare(cached[:], sum[:]) == 1 {
+		return "user:" + user, true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return "", false
+	}
+	a.mu.Lock()
+	a.verified[user] = sum
+	a.mu.Unlock()
+	return "user:" + user, true
+}
+
+// Middleware refuses requests that don't meet their route's policy and
+// records who made the ones that do
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := a.policyFor(r.URL.Path)
+
+		var principal string
+		var ok bool
+		switch policy {
+		case policyKey:
+			principal, ok = a.checkKey(r)
+		case policyBasic:
+			principal, ok = a.checkBasic(r)
+		case policyAny:
+			if principal, ok = a.checkKey(r); !ok {
+				principal, ok = a.checkBasic(r)
+			}
+		default:
+			// Public, but remember a valid key for things like rate limits
+			if principal, ok = a.checkKey(r); !ok {
+				principal = ""
+			}
+			ok = true
+		}
+
+		if !ok {
+			slog.Info("unauthorized request", "path", r.URL.Path, "policy", policy, "request_id", RequestIDFrom(r.Context()))
+			if policy == policyBasic || policy == policyAny {
+				w.Header().Set("WWW-Authenticate", `Basic realm="newsagg", charset="UTF-8"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if principal != "" {
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PrintPasswordHash is --hash-password, one password per line on stdin
+func PrintPasswordHash(in io.Reader, out io.Writer) error {
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return errors.New("no password on stdin")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", hash)
+	return err
+}
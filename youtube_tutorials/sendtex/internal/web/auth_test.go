@@ -0,0 +1,94 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBasicAuth(t *testing.T) {
+	var hash bytes.Buffer
+	if err := PrintPasswordHash(strings.NewReader("hunter2\n"), &hash); err != nil {
+		t.Fatal(err)
+	}
+	// The OpenWall "U*U" vector under the $2a$ and htpasswd's $2y$ prefixes
+	users := "alice:" + hash.String() +
+		"bob:$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW\n" +
+		"carol:$2y$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW\n"
+	a, err := loadAuthenticator("/admin/=basic", "", writeFile(t, "users", users))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(PrincipalFrom(r.Context())))
+	}))
+
+	tests := []struct {
+		user, password string
+		want           int
+	}{
+		{"alice", "hunter2", http.StatusOK},
+		{"alice", "hunter3", http.StatusUnauthorized},
+		{"bob", "U*U", http.StatusOK},
+		{"carol", "U*U", http.StatusOK},
+		{"carol", "U*V", http.StatusUnauthorized},
+		{"mallory", "U*U", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		// Twice so the cached path gets checked too
+		for range 2 {
+			r := httptest.NewRequest("GET", "/admin/", nil)
+			r.SetBasicAuth(tt.user, tt.password)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("%s:%s got %d, want %d", tt.user, tt.password, w.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && w.Body.String() != "user:"+tt.user {
+				t.Errorf("%s: principal %q", tt.user, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestBadHash(t *testing.T) {
+	_, err := loadAuthenticator("/admin/=basic", "", writeFile(t, "users", "alice:plaintext\n"))
+	if err == nil {
+		t.Fatal("plaintext password accepted")
+	}
+}
+
+func TestRuleWithoutCredentials(t *testing.T) {
+	keys := writeFile(t, "keys", "ci:secret\n")
+	tests := []struct {
+		rules, keys string
+		ok          bool
+	}{
+		{"", "", true},
+		{"/api/=public", "", true},
+		{"/api/=key", "", false},
+		{"/api/=any", "", false},
+		{"/admin/=basic", keys, false},
+		{"/api/=key", keys, true},
+		{"/api/=any", keys, true},
+	}
+	for _, tt := range tests {
+		_, err := loadAuthenticator(tt.rules, tt.keys, "")
+		if (err == nil) != tt.ok {
+			t.Errorf("rules %q with keys %q: err %v", tt.rules, tt.keys, err)
+		}
+	}
+}
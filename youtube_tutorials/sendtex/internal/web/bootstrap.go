@@ -0,0 +1,43 @@
+package web
+
+import (
+	"flag"
+	"net/http"
+	"os"
+)
+
+// Setup is the start of every binary's main: parse the flags, fill in the
+// rest from the config file and envPrefix env vars, then set up logging
+// Like --print-config, --hash-password does its job here and exits
+func Setup(envPrefix string, checks ...func() error) {
+	flag.Parse()
+	MustLoadConfig(envPrefix, checks...)
+	if err := SetupLogging(); err != nil {
+		Fatal("bad logging flags", err)
+	}
+
+	// Helper for filling in the --basic-auth file
+	if *HashPassword {
+		if err := PrintPasswordHash(os.Stdin, os.Stdout); err != nil {
+			Fatal("hashing password failed", err)
+		}
+		os.Exit(0)
+	}
+}
+
+// Run wraps the routes in the middleware every binary uses and serves them
+// until interrupted, exiting non-zero if the server can't start or stop cleanly
+func Run(routes http.Handler) {
+	// Everything is public unless --auth sets rules
+	auth, err := LoadAuthenticator()
+	if err != nil {
+		Fatal("loading credentials failed", err)
+	}
+
+	// IPs are limited before auth so failed logins count, then clients after it
+	// so a key gets its own bucket instead of sharing its IP's
+	handler := Chain(routes, RequestID, AccessLog, Compress, RecoverPanic, IPRateLimitMiddleware(), auth.Middleware, RateLimitMiddleware(), Instrument)
+	if err := Serve(handler); err != nil {
+		Fatal("server failed", err)
+	}
+}
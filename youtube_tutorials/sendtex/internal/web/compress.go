@@ -0,0 +1,167 @@
+package web
+
+import (
+	"compress/gzip"
+	"flag"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Only gzip is offered, there's no brotli encoder in the standard library
+// and every browser that asks for br asks for gzip too. A client that only
+// takes br gets the response uncompressed
+
+var (
+	compressLevel   = flag.Int("gzip-level", 6, "gzip level for responses, 1 (fastest) to 9 (smallest), 0 turns compression off")
+	compressMinSize = flag.Int("gzip-min-size", 1024, "don't compress responses known to be smaller than this many bytes")
+)
+
+// Types worth compressing, images and fonts are already compressed
+var compressibleTypes = []string{"text/html", "text/css", "text/plain", "text/javascript",
+	"text/csv", "text/markdown", "application/javascript", "application/json", "application/x-ndjson",
+	"application/xml", "image/svg+xml"}
+
+var gzipWriters = sync.Pool{
+	New: func() any {
+		zw, err := gzip.NewWriterLevel(io.Discard, *compressLevel)
+		if err != nil {
+			zw = gzip.NewWriter(io.Discard)
+		}
+		return zw
+	},
+}
+
+// compressWriter decides on the first write whether the response is worth
+// compressing, going by the headers the handler set
+type compressWriter struct {
+	http.ResponseWriter
+	zw      *gzip.Writer // nil if not compressing
+	decided bool
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if !cw.decided {
+		cw.decided = true
+		if shouldCompress(code, cw.Header(), *compressMinSize) {
+			h := cw.Header()
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+			h.Del("Accept-Ranges")
+			cw.zw = gzipWriters.Get().(*gzip.Writer)
+			cw.zw.Reset(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		// Same sniffing net/http would do, it has to happen before we pick
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.zw != nil {
+		return cw.zw.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// FlushError pushes out what gzip has buffered so far, then flushes the connection
+func (cw *compressWriter) FlushError() error {
+	// Flushing sends the headers, so decide now as Write would
+	if !cw.decided {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.zw != nil {
+		if err := cw.zw.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach Hijack and friends on the real writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the gzip stream and returns the writer to the pool
+func (cw *compressWriter) close() {
+	if cw.zw == nil {
+		return
+	}
+	cw.zw.Close()
+	gzipWriters.Put(cw.zw)
+	cw.zw = nil
+}
+
+func shouldCompress(code int, h http.Header, minSize int) bool {
+	// Bodiless, partial or already encoded responses are left alone
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified ||
+		code == http.StatusPartialContent || h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < minSize {
+		return false
+	}
+	// Event streams would sit in the gzip buffer instead of reaching the client
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	for _, t := range compressibleTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reads Accept-Encoding, honouring q=0. A gzip entry wins over
+// *, so "*;q=0, gzip" still gets gzip and "gzip;q=0, *" doesn't
+func acceptsGzip(r *http.Request) bool {
+	gzipQ, starQ := -1.0, -1.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = max(gzipQ, q)
+		case "*":
+			starQ = max(starQ, q)
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return starQ > 0
+}
+
+// Compress gzips responses for clients that accept it, br isn't offered
+// Goes inside the access log so the logged bytes are what went over the wire
+func Compress(next http.Handler) http.Handler {
+	if *compressLevel == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		// HEAD has no body, range requests need the byte offsets of the
+		// real file and upgraded connections stop being HTTP
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" ||
+			r.Header.Get("Upgrade") != "" || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
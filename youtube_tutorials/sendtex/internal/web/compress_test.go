@@ -0,0 +1,87 @@
+package web
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"x-gzip", true},
+		{"gzip, deflate, br", true},
+		{"br", false},
+		{"br;q=1.0, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.000", false},
+		{"*", true},
+		{"*;q=0", false},
+		// An explicit gzip beats * whichever comes first
+		{"*;q=0, gzip", true},
+		{"gzip, *;q=0", true},
+		{"gzip;q=0, *", false},
+		{"*, gzip;q=0", false},
+		{"identity, *;q=0", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			r.Header.Set("Accept-Encoding", tt.header)
+		}
+		if got := acceptsGzip(r); got != tt.want {
+			t.Errorf("Accept-Encoding %q: got %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	page := strings.Repeat("<p>news</p>", 200)
+	h := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, page)
+	}))
+
+	tests := []struct {
+		accept  string
+		gzipped bool
+	}{
+		{"br", false},
+		{"*;q=0, gzip", true},
+		{"gzip;q=0, *", false},
+	}
+	for _, tt := range tests {
+		accept := tt.accept
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		body := w.Body.String()
+		gzipped := w.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != tt.gzipped {
+			t.Errorf("%q: gzipped %v, want %v", accept, gzipped, tt.gzipped)
+		}
+		if gzipped {
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("%q: %v", accept, err)
+			}
+			b, _ := io.ReadAll(zr)
+			body = string(b)
+		}
+		if body != page {
+			t.Errorf("%q: body came back as %d bytes, want %d", accept, len(body), len(page))
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%q: Vary %q", accept, w.Header().Get("Vary"))
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Every setting is a flag, a config file and environment variables only
+// fill in the flags that weren't given on the command line. So the order
+// of precedence is flag, then env var, then file, then the default.
+//
+// Files are flat, keys are flag names (dashes or underscores):
+//
+//	JSON  {"addr": ":9000", "refresh-interval": "1m"}
+//	YAML  addr: ":9000"
+//	TOML  addr = ":9000"
+//
+// Lists, e.g. for --auth, may be written as arrays and are joined with commas.
+// Env vars are the prefix plus the flag name in upper case, NEWSAGG_REFRESH_INTERVAL=1m
+
+var (
+	configFile  = flag.String("config", "", "read settings from this JSON, YAML or TOML file, flags and env vars override it")
+	printConfig = flag.Bool("print-config", false, "print the effective configuration as YAML and exit")
+)
+
+// Flags that are actions rather than settings, not read from files or printed
+var configSkip = map[string]bool{"config": true, "print-config": true, "hash-password": true}
+
+// configEntry is one setting read from a file
+type configEntry struct {
+	key, value string
+	line       int // 0 if the format has no useful line numbers
+}
+
+// Where each flag's value came from, for --print-config
+var configSources = map[string]string{}
+
+// MustLoadConfig applies the config file and env vars, checks the result and
+// exits with every problem listed if anything is wrong, like flag.Parse does
+func MustLoadConfig(envPrefix string, checks ...func() error) {
+	// Checked even if loading failed, so every problem shows up in one go
+	err := errors.Join(loadConfig(envPrefix), checkConfig(checks...))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bad configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	if *printConfig {
+		writeConfig(os.Stdout)
+		os.Exit(0)
+	}
+}
+
+func loadConfig(envPrefix string) error {
+	fromFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		fromFlags[f.Name] = true
+		configSources[f.Name] = "flag"
+	})
+
+	path := *configFile
+	if env := os.Getenv(envName(envPrefix, "config")); path == "" && env != "" {
+		path = env
+	}
+	var entries []configEntry
+	if path != "" {
+		var err error
+		if entries, err = readConfigFile(path); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	fromFile := map[string]configEntry{}
+	for _, e := range entries {
+		name := strings.ReplaceAll(strings.ToLower(e.key), "_", "-")
+		if flag.Lookup(name) == nil || configSkip[name] {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", configPos(path, e.line), e.key))
+			continue
+		}
+		fromFile[name] = e
+	}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if fromFlags[f.Name] || configSkip[f.Name] {
+			return
+		}
+		if v, ok := os.LookupEnv(envName(envPrefix, f.Name)); ok {
+			if err := f.Value.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s %q: %w", envName(envPrefix, f.Name), v, err))
+			}
+			configSources[f.Name] = "env"
+			return
+		}
+		if e, ok := fromFile[f.Name]; ok {
+			if err := f.Value.Set(e.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s %q: %w", configPos(path, e.line), e.key, e.value, err))
+			}
+			configSources[f.Name] = "file"
+		}
+	})
+	return errors.Join(errs...)
+}
+
+func envName(prefix, flagName string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+func configPos(path string, line int) string {
+	if line == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s: line %d", path, line)
+}
+
+// readConfigFile picks the parser from the file extension
+func readConfigFile(path string) ([]configEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []configEntry
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		entries, err = parseJSONConfig(data)
+	case ".yaml", ".yml":
+		entries, err = parseYAMLConfig(data)
+	case ".toml":
+		entries, err = parseTOMLConfig(data)
+	default:
+		return nil, fmt.Errorf("%s: unknown config format %q, use .json, .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
+
+func parseJSONConfig(data []byte) ([]configEntry, error) {
+	var m map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	var entries []configEntry
+	for key, v := range m {
+		value, err := jsonConfigValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		entries = append(entries, configEntry{key: key, value: value})
+	}
+	// Map order is random, keep the error messages stable
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries, nil
+}
+
+func jsonConfigValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := jsonConfigValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		return "", errors.New("nested settings aren't supported, use the flag name as the key")
+	default:
+		return "", errors.New("null isn't a value, leave the setting out instead")
+	}
+}
+
+// parseYAMLConfig reads the flat subset of YAML a settings file needs:
+// key: value pairs, quoted strings, comments and lists of scalars
+func parseYAMLConfig(data []byte) ([]configEntry, error) {
+	var entries []configEntry
+	var list *configEntry // key whose "- item" lines are being read
+	var items []string
+	endList := func() {
+		if list != nil {
+			list.value = strings.Join(items, ",")
+			entries = append(entries, *list)
+			list, items = nil, nil
+		}
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		raw := sc.Text()
+		line := strings.TrimSpace(stripComment(raw))
+		if line == "" || line == "---" {
+			continue
+		}
+		indented := raw[0] == ' ' || raw[0] == '\t'
+		if item, ok := strings.CutPrefix(line, "-"); ok && list != nil && (item == "" || item[0] == ' ') {
+			v, err := unquoteConfig(strings.TrimSpace(item))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			items = append(items, v)
+			continue
+		}
+		if indented {
+			return nil, fmt.Errorf("line %d: nested settings aren't supported, use the flag name as the key", n)
+		}
+		endList()
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("line %d: want key: value", n)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if value == "" {
+			// Either a list follows or the value is empty
+			list = &configEntry{key: key, line: n}
+			continue
+		}
+		v, err := configScalarOrList(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		entries = append(entries, configEntry{key: key, value: v, line: n})
+	}
+	endList()
+	return entries, sc.Err()
+}
+
+// parseTOMLConfig reads the flat subset of TOML a settings file needs:
+// key = value pairs, strings, numbers, booleans, comments and one line arrays
+func parseTOMLConfig(data []byte) ([]configEntry, error) {
+	var entries []configEntry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables aren't supported, use the flag name as the key", n)
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("line %d: want key = value", n)
+		}
+		key, value = strings.Trim(strings.TrimSpace(key), `"`), strings.TrimSpace(value)
+		v, err := configScalarOrList(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		entries = append(entries, configEntry{key: key, value: v, line: n})
+	}
+	return entries, sc.Err()
+}
+
+// configScalarOrList reads a value or a [a, b] list, lists are joined with commas
+func configScalarOrList(value string) (string, error) {
+	inner, ok := strings.CutPrefix(value, "[")
+	if !ok {
+		return unquoteConfig(value)
+	}
+	inner, ok = strings.CutSuffix(inner, "]")
+	if !ok {
+		return "", errors.New("lists must open and close on the same line")
+	}
+	var items []string
+	for _, item := range splitConfigList(inner) {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		v, err := unquoteConfig(item)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, v)
+	}
+	return strings.Join(items, ","), nil
+}
+
+// splitConfigList splits on commas that aren't inside quotes
+func splitConfigList(s string) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquoteConfig reads a "double quoted" string with escapes, a 'single quoted'
+// literal or a bare word
+func unquoteConfig(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("bad quoted string %s", s)
+		}
+		return v, nil
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		// YAML escapes a quote in a single quoted string by doubling it
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case s != "" && (s[0] == '"' || s[0] == '\''):
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	return s, nil
+}
+
+// stripComment cuts a # comment off a line, leaving # inside quotes alone
+// A comment has to start the line or follow a space, so URL fragments survive
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// writeConfig prints every setting as YAML that --config can read back,
+// with where its value came from
+func writeConfig(w io.Writer) {
+	flag.VisitAll(func(f *flag.Flag) {
+		if configSkip[f.Name] {
+			return
+		}
+		value := f.Value.String()
+		if g, ok := f.Value.(flag.Getter); ok {
+			if _, isString := g.Get().(string); isString {
+				value = strconv.Quote(value)
+			}
+		}
+		source := configSources[f.Name]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(w, "%s: %s # %s\n", f.Name, value, source)
+	})
+}
+
+// checkConfig validates the settings shared by every binary plus any checks
+// the binary adds, reporting all the problems at once
+func checkConfig(checks ...func() error) error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(*listenAddr)
+	check(err == nil, "--addr %q: want host:port or :port", *listenAddr)
+	if *redirectAddr != "" {
+		_, _, err := net.SplitHostPort(*redirectAddr)
+		check(err == nil, "--redirect-addr %q: want host:port or :port", *redirectAddr)
+	}
+	check(*readTimeout >= 0 && *writeTimeout >= 0 && *idleTimeout >= 0, "--read-timeout, --write-timeout and --idle-timeout can't be negative")
+	check(*shutdownTimeout > 0, "--shutdown-timeout must be more than 0")
+	check((*certFile == "") == (*keyFile == ""), "--cert and --key must be given together")
+	check(*hstsMaxAge >= 0, "--hsts-max-age can't be negative")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(*logLevel)) == nil, "--log-level %q: must be debug, info, warn or error", *logLevel)
+	check(*logFormat == "text" || *logFormat == "json", "--log-format %q: must be text or json", *logFormat)
+
+	check(*rateLimit >= 0, "--rate-limit can't be negative")
+	check(*rateBurst >= 1, "--rate-burst must be at least 1")
+	check(*ipLimit >= 0, "--ip-rate-limit can't be negative")
+	check(*ipBurst >= 1, "--ip-rate-burst must be at least 1")
+	check(*rateIdle > 0, "--rate-idle must be more than 0")
+	check(*compressLevel >= 0 && *compressLevel <= 9, "--gzip-level %d: must be 0 to 9", *compressLevel)
+	check(*compressMinSize >= 0, "--gzip-min-size can't be negative")
+
+	for _, c := range checks {
+		if err := c(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckURL is for settings that must be an absolute http or https URL
+func CheckURL(name, value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("--%s %q: want an http:// or https:// URL", name, value)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package web
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFlags swaps the default flag set for a small one for the test, the
+// config code only ever looks at the default set
+func testFlags(t *testing.T) *flag.FlagSet {
+	t.Helper()
+	oldFlags, oldSources, oldFile := flag.CommandLine, configSources, *configFile
+	t.Cleanup(func() {
+		flag.CommandLine, configSources, *configFile = oldFlags, oldSources, oldFile
+	})
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("addr", ":8000", "")
+	fs.String("auth", "", "")
+	fs.Bool("debug", false, "")
+	fs.Int("history", 100, "")
+	fs.Duration("refresh-interval", time.Minute, "")
+	fs.String("config", "", "")
+	flag.CommandLine, configSources, *configFile = fs, map[string]string{}, ""
+	return fs
+}
+
+func TestConfigFormats(t *testing.T) {
+	tests := []struct {
+		name, content string
+	}{
+		{"settings.json", `{
+			"addr": "localhost:9000 # not a comment",
+			"auth": ["/admin/=basic", "/api/=key"],
+			"debug": true,
+			"history": 5,
+			"refresh_interval": "2m"
+		}`},
+		{"settings.yaml", `---
+# the server
+addr: "localhost:9000 # not a comment"
+auth:
+  - /admin/=basic
+  - '/api/=key'
+debug: true # on for now
+history: 5
+REFRESH_INTERVAL: 2m
+`},
+		{"settings.yml", `addr: 'localhost:9000 # not a comment'
+auth: ["/admin/=basic", /api/=key]
+debug: true
+history: 5
+refresh-interval: 2m
+`},
+		{"settings.toml", `# the server
+addr = "localhost:9000 # not a comment"
+auth = ["/admin/=basic", '/api/=key']
+debug = true
+"history" = 5 # kept short
+refresh_interval = "2m"
+`},
+	}
+	want := map[string]string{
+		"addr":             "localhost:9000 # not a comment",
+		"auth":             "/admin/=basic,/api/=key",
+		"debug":            "true",
+		"history":          "5",
+		"refresh-interval": "2m0s",
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := testFlags(t)
+			*configFile = writeFile(t, tt.name, tt.content)
+			if err := loadConfig("WEBTEST_"); err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range want {
+				if got := fs.Lookup(name).Value.String(); got != value {
+					t.Errorf("%s = %q, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	fs := testFlags(t)
+	*configFile = writeFile(t, "settings.yaml", "addr: :9000\nhistory: 1\nrefresh-interval: 1h\n")
+	t.Setenv("WEBTEST_HISTORY", "2")
+	t.Setenv("WEBTEST_REFRESH_INTERVAL", "2h")
+	if err := fs.Parse([]string{"--history", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig("WEBTEST_"); err != nil {
+		t.Fatal(err)
+	}
+
+	// history is set everywhere, refresh-interval by env and file, addr only by the file
+	var out strings.Builder
+	writeConfig(&out)
+	want := `addr: ":9000" # file
+auth: "" # default
+debug: false # default
+history: 3 # flag
+refresh-interval: 2h0m0s # env
+`
+	if out.String() != want {
+		t.Errorf("--print-config wrote\n%s\nwant\n%s", out.String(), want)
+	}
+
+	// What it prints reads back the same
+	fs = testFlags(t)
+	*configFile = writeFile(t, "printed.yaml", want)
+	if err := loadConfig("WEBTEST_"); err != nil {
+		t.Fatalf("reading --print-config back: %v", err)
+	}
+	if got := fs.Lookup("refresh-interval").Value.String(); got != "2h0m0s" {
+		t.Errorf("read back refresh-interval %q", got)
+	}
+}
+
+func TestConfigFileFromEnv(t *testing.T) {
+	fs := testFlags(t)
+	t.Setenv("WEBTEST_CONFIG", writeFile(t, "settings.toml", "history = 7\n"))
+	if err := loadConfig("WEBTEST_"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.Lookup("history").Value.String(); got != "7" {
+		t.Errorf("history = %s, want 7 from the file WEBTEST_CONFIG names", got)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, content string
+		env           string // value for WEBTEST_HISTORY, if any
+		want          []string
+	}{
+		{"bad.json", `{"addr": ":9000",}`, "", []string{"bad.json: invalid character"}},
+		{"nested.json", `{"server": {"addr": ":9000"}}`, "", []string{"server: nested settings"}},
+		{"null.json", `{"addr": null}`, "", []string{"addr: null isn't a value"}},
+		{"unknown.json", `{"port": 9000, "config": "x.json"}`, "", []string{
+			`unknown.json: unknown setting "config"`, `unknown.json: unknown setting "port"`}},
+		{"nested.yaml", "addr: :9000\nserver:\n  addr: :9000\n", "", []string{"nested.yaml: line 3: nested settings"}},
+		{"nokey.yaml", "addr: :9000\n\njust words\n", "", []string{"nokey.yaml: line 3: want key: value"}},
+		{"quote.yaml", "# comment\naddr: \":9000\n", "", []string{"quote.yaml: line 2: unterminated string"}},
+		{"unknown.yaml", "addr: :9000\nport: 9000\n", "", []string{`unknown.yaml: line 2: unknown setting "port"`}},
+		{"value.yaml", "debug: true\nhistory: lots\n", "", []string{`value.yaml: line 2: history "lots"`}},
+		{"table.toml", "addr = \":9000\"\n[server]\n", "", []string{"table.toml: line 2: tables aren't supported"}},
+		{"noequals.toml", "addr \":9000\"\n", "", []string{"noequals.toml: line 1: want key = value"}},
+		{"list.toml", "history = 5\nauth = [\"/admin/=basic\",\n", "", []string{"list.toml: line 2: lists must open and close"}},
+		{"escape.toml", `addr = "\q"`, "", []string{`escape.toml: line 1: bad quoted string`}},
+		{"settings.ini", "addr = :9000\n", "", []string{`unknown config format ".ini"`}},
+		// Every problem shows up, not just the first
+		{"both.toml", "port = 1\nhistory = \"x\"\n", "", []string{
+			`both.toml: line 1: unknown setting "port"`, `both.toml: line 2: history "x"`}},
+		{"env.toml", "history = 5\n", "lots", []string{`WEBTEST_HISTORY "lots"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testFlags(t)
+			if tt.env != "" {
+				t.Setenv("WEBTEST_HISTORY", tt.env)
+			}
+			*configFile = writeFile(t, tt.name, tt.content)
+			err := loadConfig("WEBTEST_")
+			if err == nil {
+				t.Fatal("loaded, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't mention %q", err, want)
+				}
+			}
+		})
+	}
+}
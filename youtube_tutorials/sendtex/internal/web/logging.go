@@ -0,0 +1,41 @@
+package web
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+var (
+	logLevel  = flag.String("log-level", "info", "minimum level to log: debug, info, warn or error")
+	logFormat = flag.String("log-format", "text", "log output format: text or json")
+)
+
+// SetupLogging installs the structured logger as the default, the plain
+// log package is routed through it as well
+func SetupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("bad --log-level %q: %w", *logLevel, err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch *logFormat {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("bad --log-format %q: must be text or json", *logFormat)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// Fatal logs the error and exits non-zero
+func Fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
@@ -0,0 +1,290 @@
+package web
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Small metrics registry writing the Prometheus text exposition format
+// so /metrics can be scraped without pulling in the client library
+
+type metric interface {
+	writeTo(w *bufio.Writer)
+}
+
+var metricsRegistry struct {
+	mu   sync.Mutex
+	list []metric
+}
+
+func register(m metric) {
+	metricsRegistry.mu.Lock()
+	metricsRegistry.list = append(metricsRegistry.list, m)
+	metricsRegistry.mu.Unlock()
+}
+
+// Default latency buckets, in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Label values are joined into one map key
+const labelSep = "\xff"
+
+// metricVec holds the per label set values shared by the metric types
+type metricVec struct {
+	name, help, kind string
+	labels           []string
+
+	mu   sync.Mutex
+	keys map[string][]string
+}
+
+func (v *metricVec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", v.name, len(values), len(v.labels)))
+	}
+	k := strings.Join(values, labelSep)
+	if _, ok := v.keys[k]; !ok {
+		v.keys[k] = append([]string(nil), values...)
+	}
+	return k
+}
+
+// Keys in a stable order so the output doesn't jump around
+func (v *metricVec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.keys))
+	for k := range v.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *metricVec) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+}
+
+// Format the {a="x",b="y"} part, extra is appended for histogram buckets
+func (v *metricVec) labelString(values []string, extra ...string) string {
+	var pairs []string
+	for i, l := range v.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatValue(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// CounterVec only goes up, GaugeVec can be set to anything
+type CounterVec struct {
+	metricVec
+	values map[string]float64
+}
+
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		metricVec: metricVec{name: name, help: help, kind: "counter", labels: labels, keys: map[string][]string{}},
+		values:    map[string]float64{},
+	}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.mu.Lock()
+	c.values[c.key(labelValues)] += delta
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) writeTo(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, k := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(c.keys[k]), formatValue(c.values[k]))
+	}
+}
+
+type GaugeVec struct {
+	CounterVec
+}
+
+func NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{CounterVec{
+		metricVec: metricVec{name: name, help: help, kind: "gauge", labels: labels, keys: map[string][]string{}},
+		values:    map[string]float64{},
+	}}
+	register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	g.values[g.key(labelValues)] = value
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// GaugeFunc reads its value when scraped
+type GaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) writeTo(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatValue(g.fn()))
+}
+
+// HistogramVec counts observations into cumulative buckets
+type HistogramVec struct {
+	metricVec
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // one per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		metricVec: metricVec{name: name, help: help, kind: "histogram", labels: labels, keys: map[string][]string{}},
+		buckets:   buckets,
+		series:    map[string]*histogramSeries{},
+	}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := h.key(labelValues)
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *HistogramVec) writeTo(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, k := range h.sortedKeys() {
+		s, values := h.series[k], h.keys[k]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", formatValue(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(values), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(values), s.count)
+	}
+}
+
+// MetricsHandler serves every registered metric at /metrics
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		metricsRegistry.mu.Lock()
+		for _, m := range metricsRegistry.list {
+			m.writeTo(bw)
+		}
+		metricsRegistry.mu.Unlock()
+		bw.Flush()
+	})
+}
+
+// HTTP server metrics, labelled by the mux pattern so paths with IDs
+// don't each get their own series
+var (
+	httpRequests = NewCounter("http_requests_total",
+		"HTTP requests handled, by route, method and status code.", "route", "method", "code")
+	httpDuration = NewHistogram("http_request_duration_seconds",
+		"Time taken to serve HTTP requests, by route.", DefaultBuckets, "route")
+	httpInFlight = NewGauge("http_requests_in_flight",
+		"HTTP requests currently being served.")
+	_ = NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.",
+		func() float64 { return float64(runtime.NumGoroutine()) })
+)
+
+// Instrument records request counts and latency per route
+// Must wrap the mux directly, the route is read from r.Pattern once the mux has matched it
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		sr := recorderOf(w)
+		if sr == nil {
+			sr = &statusRecorder{ResponseWriter: w}
+			w = sr
+		}
+		next.ServeHTTP(w, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		code := sr.status
+		if code == 0 {
+			code = http.StatusOK
+		}
+		httpRequests.Inc(route, r.Method, strconv.Itoa(code))
+		httpDuration.Observe(time.Since(start).Seconds(), route)
+	})
+}
@@ -0,0 +1,191 @@
+package web
+
+import (
+	"context"
+	"flag"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	rateLimit  = flag.Float64("rate-limit", 5, "requests per second allowed per client, 0 turns rate limiting off")
+	rateBurst  = flag.Int("rate-burst", 20, "requests a client may make in a burst before being limited")
+	ipLimit    = flag.Float64("ip-rate-limit", 20, "requests per second allowed per IP before auth, shared by every key and user on it, 0 turns it off")
+	ipBurst    = flag.Int("ip-rate-burst", 50, "requests an IP may make in a burst before being limited")
+	rateIdle   = flag.Duration("rate-idle", 10*time.Minute, "forget a client's bucket after it has been idle this long")
+	trustProxy = flag.Bool("trust-proxy", false, "take the client IP from the last X-Forwarded-For entry, only when behind a load balancer")
+)
+
+var (
+	rateLimited = NewCounter("http_rate_limited_total",
+		"Requests refused with 429 by the rate limiter, by ip (before auth) or client (after).", "by")
+	_ = NewGaugeFunc("http_rate_limit_buckets", "Clients currently tracked by the rate limiters.",
+		func() float64 { return float64(ipLimiter.size() + limiter.size()) })
+)
+
+// Probes from the load balancer and scrapes shouldn't use up anyone's budget
+var rateLimitExempt = []string{"/healthz", "/readyz", "/metrics"}
+
+// bucket is a token bucket, same idea as the burstyLimiter in rateLimiting()
+// from the concurrency examples: it starts full so a client gets a burst, then
+// refills at a steady rate. Instead of a ticker goroutine per client filling a
+// channel, the refill is worked out from the time since the last request.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps one bucket per client, whatever key says the client is
+type rateLimiter struct {
+	by    string // label for the metrics
+	key   func(*http.Request) string
+	rate  float64 // tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// ipLimiter runs before auth so failed logins and bad keys are throttled too,
+// limiter runs after it so a key or user gets its own bucket instead of sharing its IP's
+var ipLimiter, limiter *rateLimiter
+
+func newRateLimiter(by string, key func(*http.Request) string, rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		by:      by,
+		key:     key,
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow takes a token from the client's bucket
+// If there isn't one it says how long until there will be
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+func (l *rateLimiter) size() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// evict drops buckets idle for longer than idle so memory stays bounded
+// A dropped bucket would have refilled by then anyway
+func (l *rateLimiter) evict(now time.Time, idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for client, b := range l.buckets {
+		if now.Sub(b.last) > idle {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// run evicts idle buckets until ctx is done
+func (l *rateLimiter) run(ctx context.Context, idle time.Duration) {
+	ticker := time.NewTicker(max(idle/2, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.evict(now, idle)
+		}
+	}
+}
+
+// clientKey is who the request is charged to
+// Must run after the auth middleware so a valid key or user is in the context
+func clientKey(r *http.Request) string {
+	if p := PrincipalFrom(r.Context()); p != "" {
+		return p
+	}
+	return "ip:" + clientIP(r)
+}
+
+func clientIP(r *http.Request) string {
+	if *trustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			hops := strings.Split(xff, ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Middleware answers 429 with Retry-After once a client runs out of tokens
+func (l *rateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range rateLimitExempt {
+			if r.URL.Path == path {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		ok, wait := l.allow(l.key(r), time.Now())
+		if !ok {
+			rateLimited.Inc(l.by)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IPRateLimitMiddleware sets up the per-IP limiter from the flags, it goes before auth
+// With --ip-rate-limit 0 it passes everything through
+func IPRateLimitMiddleware() Middleware {
+	if *ipLimit <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	ipLimiter = newRateLimiter("ip", clientIP, *ipLimit, *ipBurst)
+	go ipLimiter.run(context.Background(), *rateIdle)
+	return ipLimiter.Middleware
+}
+
+// RateLimitMiddleware sets up the per-client limiter from the flags, it goes after auth
+// With --rate-limit 0 it passes everything through
+func RateLimitMiddleware() Middleware {
+	if *rateLimit <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	limiter = newRateLimiter("client", clientKey, *rateLimit, *rateBurst)
+	go limiter.run(context.Background(), *rateIdle)
+	return limiter.Middleware
+}
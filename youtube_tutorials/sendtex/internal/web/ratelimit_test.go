@@ -0,0 +1,46 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFailedLoginsAreLimited(t *testing.T) {
+	a, err := loadAuthenticator("/api/=key", writeFile(t, "keys", "ci:secret\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := newRateLimiter("ip", clientIP, 1, 3)
+	client := newRateLimiter("client", clientKey, 1, 2)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := Chain(ok, ip.Middleware, a.Middleware, client.Middleware)
+
+	get := func(key, addr string) int {
+		r := httptest.NewRequest("GET", "/api/news", nil)
+		r.RemoteAddr = addr
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Bad keys never reach the per-client limiter, so the IP's bucket has to stop them
+	for i, want := range []int{401, 401, 401, 429} {
+		if got := get("wrong", "192.0.2.1:1000"); got != want {
+			t.Errorf("bad key %d: got %d, want %d", i, got, want)
+		}
+	}
+	// A good key from elsewhere is charged to the key after auth
+	for i, want := range []int{200, 200, 429} {
+		if got := get("secret", "192.0.2.2:1000"); got != want {
+			t.Errorf("good key %d: got %d, want %d", i, got, want)
+		}
+	}
+	// and the key's bucket is empty whichever IP it comes from
+	if got := get("secret", "192.0.2.3:1000"); got != 429 {
+		t.Errorf("good key on a new IP: got %d, want 429", got)
+	}
+}
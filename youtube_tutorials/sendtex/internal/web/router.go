@@ -0,0 +1,74 @@
+package web
+
+import (
+	"net/http"
+)
+
+// Router takes ServeMux patterns with methods and path parameters
+// ("GET /news/{id}", read with r.PathValue("id")) and answers unknown
+// paths and wrong methods with the rendered error page instead of plain text
+type Router struct {
+	mux   *http.ServeMux
+	pages *TemplateSet // has the error.html page
+}
+
+func NewRouter(pages *TemplateSet) *Router {
+	return &Router{mux: http.NewServeMux(), pages: pages}
+}
+
+func (rt *Router) Handle(pattern string, h http.Handler) {
+	rt.mux.Handle(pattern, h)
+}
+
+func (rt *Router) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	rt.mux.HandleFunc(pattern, h)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Redirects like /about -> /about/ come back with a pattern too
+	if _, pattern := rt.mux.Handler(r); pattern != "" {
+		rt.mux.ServeHTTP(w, r)
+		return
+	}
+
+	// No route, let the mux say if it's a 404 or a 405 and keep its Allow header
+	probe := &statusProbe{header: http.Header{}}
+	h, _ := rt.mux.Handler(r)
+	h.ServeHTTP(probe, r)
+	if allow := probe.header.Get("Allow"); allow != "" {
+		w.Header().Set("Allow", allow)
+	}
+	rt.pages.RenderError(w, r, probe.status)
+}
+
+// statusProbe records the status of the mux's own error handlers and drops the body
+type statusProbe struct {
+	header http.Header
+	status int
+}
+
+func (p *statusProbe) Header() http.Header         { return p.header }
+func (p *statusProbe) Write(b []byte) (int, error) { return len(b), nil }
+func (p *statusProbe) WriteHeader(code int)        { p.status = code }
+
+// ErrorPage is the data for error.html
+type ErrorPage struct {
+	Title   string
+	Status  int
+	Message string
+	Path    string
+}
+
+// RenderError shows the error page with the given status
+func (ts *TemplateSet) RenderError(w http.ResponseWriter, r *http.Request, status int) {
+	if status == 0 {
+		status = http.StatusNotFound
+	}
+	text := http.StatusText(status)
+	ts.RenderStatus(w, status, "error.html", ErrorPage{
+		Title:   text,
+		Status:  status,
+		Message: text,
+		Path:    r.URL.Path,
+	})
+}
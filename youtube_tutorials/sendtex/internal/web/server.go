@@ -0,0 +1,114 @@
+// Package web is the server side shared by the web binaries, so a fix to
+// the server, its middleware or its settings lands in all of them at once.
+// Settings are flags on the default flag set, next to each binary's own.
+package web
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	listenAddr      = flag.String("addr", ":8080", "address to listen on")
+	readTimeout     = flag.Duration("read-timeout", 10*time.Second, "max time to read a request")
+	writeTimeout    = flag.Duration("write-timeout", 60*time.Second, "max time to write a response")
+	idleTimeout     = flag.Duration("idle-timeout", 120*time.Second, "max time to keep an idle connection open")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 15*time.Second, "max time to drain in-flight requests on shutdown")
+)
+
+func newServer(addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: *readTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		Protocols:         new(http.Protocols),
+	}
+	// HTTP/2 is only negotiated over TLS, plain connections stay on HTTP/1.1
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(true)
+	return srv
+}
+
+// Serve runs the server until SIGINT or SIGTERM, then stops accepting
+// connections and waits for in-flight requests to finish
+// Serves HTTPS when a certificate is configured, optionally with a plain
+// HTTP listener that redirects to it
+// Returns an error if an address can't be bound or the drain times out
+func Serve(handler http.Handler) error {
+	var servers []*http.Server
+	serveErr := make(chan error, 2)
+
+	start := func(srv *http.Server, ln net.Listener) {
+		servers = append(servers, srv)
+		go func() {
+			if srv.TLSConfig != nil {
+				slog.Info("listening", "addr", ln.Addr().String(), "tls", true)
+				serveErr <- srv.ServeTLS(ln, "", "")
+			} else {
+				slog.Info("listening", "addr", ln.Addr().String(), "tls", false)
+				serveErr <- srv.Serve(ln)
+			}
+		}()
+	}
+
+	srv := newServer(*listenAddr, handler)
+	if tlsEnabled() {
+		cfg, err := tlsConfig()
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = cfg
+		srv.Handler = hsts(handler)
+	}
+
+	// Bind everything first so a bad address or a port in use fails straight away
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	var redirectLn net.Listener
+	if srv.TLSConfig != nil && *redirectAddr != "" {
+		if redirectLn, err = net.Listen("tcp", *redirectAddr); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	start(srv, ln)
+	if redirectLn != nil {
+		start(newServer(*redirectAddr, redirectToHTTPS(ln.Addr().String())), redirectLn)
+	}
+
+	var runErr error
+	select {
+	case runErr = <-serveErr:
+	case <-ctx.Done():
+		slog.Info("shutting down, draining in-flight requests", "timeout", *shutdownTimeout)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.Shutdown(shutdownCtx); err != nil && runErr == nil {
+			runErr = err
+		}
+	}
+	if runErr != nil && !errors.Is(runErr, http.ErrServerClosed) {
+		return runErr
+	}
+	return nil
+}
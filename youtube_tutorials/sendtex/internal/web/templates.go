@@ -0,0 +1,126 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// TemplateSet parses every page once at startup and hands them to the handlers
+// Pages can share layout and partial files, these are parsed into every page
+type TemplateSet struct {
+	fsys   fs.FS
+	shared []string // glob patterns for the layout and partials
+	pages  []string
+
+	mu     sync.RWMutex
+	parsed map[string]*template.Template
+}
+
+func NewTemplateSet(fsys fs.FS, shared []string, pages ...string) (*TemplateSet, error) {
+	ts := &TemplateSet{fsys: fsys, shared: shared, pages: pages}
+	if err := ts.parse(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// Parse all the pages, the old set is only replaced if every page parses
+func (ts *TemplateSet) parse() error {
+	parsed := make(map[string]*template.Template, len(ts.pages))
+	for _, page := range ts.pages {
+		t, err := template.New(path.Base(page)).ParseFS(ts.fsys, append(ts.shared, page)...)
+		if err != nil {
+			return fmt.Errorf("parsing template %s: %w", page, err)
+		}
+		parsed[page] = t
+	}
+
+	ts.mu.Lock()
+	ts.parsed = parsed
+	ts.mu.Unlock()
+	return nil
+}
+
+func (ts *TemplateSet) lookup(page string) (*template.Template, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	t, ok := ts.parsed[page]
+	if !ok {
+		return nil, fmt.Errorf("template %s not loaded", page)
+	}
+	return t, nil
+}
+
+// Render executes a page into a buffer before writing anything, so a template
+// error gives a clean 500 instead of a half written page with a 200
+// Pages that use the shared layout are rendered through it
+func (ts *TemplateSet) Render(w http.ResponseWriter, page string, data any) {
+	ts.RenderStatus(w, http.StatusOK, page, data)
+}
+
+// RenderStatus is Render with a status code other than 200
+func (ts *TemplateSet) RenderStatus(w http.ResponseWriter, status int, page string, data any) {
+	var buf bytes.Buffer
+	if err := ts.Execute(&buf, page, data); err != nil {
+		slog.Error("rendering template failed", "page", page, "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// Execute writes a page to w, through the layout if it has one
+func (ts *TemplateSet) Execute(w io.Writer, page string, data any) error {
+	t, err := ts.lookup(page)
+	if err != nil {
+		return err
+	}
+	if layout := t.Lookup("layout"); layout != nil {
+		t = layout
+	}
+	return t.Execute(w, data)
+}
+
+// Dev mode, poll the template dir and re-parse whenever a file changes
+// There's no file watcher in the standard library so this compares mod times
+func (ts *TemplateSet) Watch(dir string, interval time.Duration) {
+	last := latestModTime(dir)
+	for range time.Tick(interval) {
+		mod := latestModTime(dir)
+		if !mod.After(last) {
+			continue
+		}
+		last = mod
+		if err := ts.parse(); err != nil {
+			// Keep serving the previous templates until the file is fixed
+			slog.Error("template reload failed", "dir", dir, "err", err)
+			continue
+		}
+		slog.Info("templates reloaded", "dir", dir)
+	}
+}
+
+func latestModTime(dir string) time.Time {
+	var latest time.Time
+	fs.WalkDir(os.DirFS(dir), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return latest
+}
@@ -0,0 +1,115 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	certFile     = flag.String("cert", "", "TLS certificate file, serves HTTPS when set with --key")
+	keyFile      = flag.String("key", "", "TLS private key file")
+	selfSigned   = flag.Bool("self-signed", false, "serve HTTPS with a generated self-signed certificate, for local development")
+	redirectAddr = flag.String("redirect-addr", "", "when serving HTTPS, also listen here on plain HTTP and redirect to HTTPS")
+	hstsMaxAge   = flag.Duration("hsts-max-age", 365*24*time.Hour, "max-age sent in the Strict-Transport-Security header, 0 to disable")
+)
+
+func tlsEnabled() bool {
+	return *certFile != "" || *keyFile != "" || *selfSigned
+}
+
+// tlsConfig loads the certificate from --cert/--key or generates a self-signed one
+func tlsConfig() (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case *certFile != "" || *keyFile != "":
+		if *certFile == "" || *keyFile == "" {
+			return nil, errors.New("--cert and --key must be given together")
+		}
+		cert, err = tls.LoadX509KeyPair(*certFile, *keyFile)
+	default:
+		cert, err = selfSignedCert([]string{"localhost", "127.0.0.1", "::1"}, 365*24*time.Hour)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
+
+// selfSignedCert creates an in-memory certificate for the given hosts
+// Browsers will warn about it, it's only meant for local development
+func selfSignedCert(hosts []string, validFor time.Duration) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"go-learning dev"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// hsts tells browsers to only use HTTPS for this host from now on
+func hsts(next http.Handler) http.Handler {
+	if *hstsMaxAge <= 0 {
+		return next
+	}
+	value := fmt.Sprintf("max-age=%d", int(hstsMaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same path on the HTTPS address
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
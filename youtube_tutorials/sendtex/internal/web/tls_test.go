@@ -0,0 +1,110 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		httpsAddr, host, target, want string
+	}{
+		{":443", "example.com", "/agg/?page=2", "https://example.com/agg/?page=2"},
+		{":443", "example.com:80", "/", "https://example.com/"},
+		{":8443", "example.com:8080", "/news/abc", "https://example.com:8443/news/abc"},
+		{"127.0.0.1:8443", "localhost", "/", "https://localhost:8443/"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		redirectToHTTPS(tt.httpsAddr).ServeHTTP(w, r)
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("%s%s: status %d", tt.host, tt.target, w.Code)
+		}
+		if got := w.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s%s: redirected to %q, want %q", tt.host, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	defer func(old time.Duration) { *hstsMaxAge = old }(*hstsMaxAge)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	*hstsMaxAge = 24 * time.Hour
+	w := httptest.NewRecorder()
+	hsts(ok).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=86400" {
+		t.Errorf("Strict-Transport-Security %q", got)
+	}
+
+	*hstsMaxAge = 0
+	w = httptest.NewRecorder()
+	hsts(ok).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if got := w.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("Strict-Transport-Security %q with --hsts-max-age 0", got)
+	}
+}
+
+// protoServer answers with the HTTP major version the handler saw
+func protoServer() *httptest.Server {
+	srv := httptest.NewUnstartedServer(nil)
+	// newServer's settings, so this checks the protocols it allows
+	srv.Config = newServer("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strconv.Itoa(r.ProtoMajor))
+	}))
+	return srv
+}
+
+func getProto(t *testing.T, srv *httptest.Server) (string, *http.Response) {
+	t.Helper()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body), resp
+}
+
+func TestHTTP2OverTLS(t *testing.T) {
+	defer func(old bool) { *selfSigned = old }(*selfSigned)
+	*selfSigned = true
+	cfg, err := tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := protoServer()
+	srv.Config.Handler = hsts(srv.Config.Handler)
+	srv.TLS = cfg // the self-signed certificate, which srv.Client trusts
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	got, resp := getProto(t, srv)
+	if got != "2" || resp.ProtoMajor != 2 {
+		t.Errorf("over TLS the handler saw HTTP/%s and the client HTTP/%d, want 2", got, resp.ProtoMajor)
+	}
+	if resp.Header.Get("Strict-Transport-Security") == "" {
+		t.Error("no Strict-Transport-Security over TLS")
+	}
+}
+
+func TestHTTP1WithoutTLS(t *testing.T) {
+	srv := protoServer()
+	srv.Start()
+	defer srv.Close()
+
+	if got, _ := getProto(t, srv); got != "1" {
+		t.Errorf("plain HTTP got HTTP/%s, want 1", got)
+	}
+}
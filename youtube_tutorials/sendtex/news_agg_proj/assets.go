@@ -0,0 +1,56 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+// Templates and static assets are compiled into the binary
+// so the page works offline and without any CDN
+
+//go:embed newsaggtemplate.html error.html
+var templateFS embed.FS
+
+//go:embed static
+var staticFS embed.FS
+
+var (
+	templateDir = flag.String("templates", "", "load templates from this dir instead of the embedded copy")
+	devMode     = flag.Bool("dev", false, "watch the template files and reload them on change")
+)
+
+// Parsed once in main, shared by all handlers
+var pages *web.TemplateSet
+
+// Load the templates from disk if asked to, else from the embedded copy
+// Dev mode defaults to the current dir so edits show up without a rebuild
+func loadTemplates() (*web.TemplateSet, error) {
+	var fsys fs.FS = templateFS
+	dir := *templateDir
+	if dir == "" && *devMode {
+		dir = "."
+	}
+	if dir != "" {
+		fsys = os.DirFS(dir)
+	}
+
+	ts, err := web.NewTemplateSet(fsys, nil, "newsaggtemplate.html", "error.html")
+	if err != nil {
+		return nil, err
+	}
+	if *devMode {
+		go ts.Watch(dir, 500*time.Millisecond)
+	}
+	return ts, nil
+}
+
+// Serves /static/... straight from the embedded static dir
+func staticHandler() http.Handler {
+	return http.FileServerFS(staticFS)
+}
@@ -2,11 +2,16 @@ package main
 
 import (
 	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
-	"html/template"
-	"io/ioutil"
+	"io"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
 )
 
 // Vid 17
@@ -33,7 +38,57 @@ type NewsMap struct {
 // NewsAggPage ...
 type NewsAggPage struct {
 	Title string
-	News  map[string]NewsMap
+	Rows  []NewsRow
+	Query tableQuery
+	Total int
+	Pages int
+}
+
+var (
+	sitemapIndexURL = flag.String("sitemap-index", "https://www.washingtonpost.com/news-sitemaps/index.xml", "news sitemap index to aggregate")
+	pageTitle       = flag.String("title", "Amazing News Agg Page", "title of the /agg/ page")
+)
+
+// Fetch pipeline metrics, sources are labelled by sitemap URL
+var (
+	sitemapFetchDuration = web.NewHistogram("newsagg_sitemap_fetch_duration_seconds",
+		"Time taken to fetch and parse a sitemap, by source.", web.DefaultBuckets, "source")
+	sitemapFetchErrors = web.NewCounter("newsagg_sitemap_fetch_errors_total",
+		"Sitemap fetches that failed, by source.", "source")
+	refreshesTotal = web.NewCounter("newsagg_refreshes_total",
+		"Aggregation refreshes run.")
+	refreshArticles = web.NewGauge("newsagg_refresh_articles",
+		"Articles aggregated by the most recent refresh.")
+)
+
+// fetchXML gets a sitemap and decodes it into v, recording how long it took
+func fetchXML(location string, v any) error {
+	start := time.Now()
+	err := getXML(location, v)
+	sitemapFetchDuration.Observe(time.Since(start).Seconds(), location)
+	if err != nil {
+		sitemapFetchErrors.Inc(location)
+	}
+	return err
+}
+
+func getXML(location string, v any) error {
+	resp, err := http.Get(location)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", location, resp.Status)
+	}
+	bytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", location, err)
+	}
+	if err := xml.Unmarshal(bytes, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", location, err)
+	}
+	return nil
 }
 
 func newsAggHandler(w http.ResponseWriter, r *http.Request) {
@@ -42,50 +97,75 @@ func newsAggHandler(w http.ResponseWriter, r *http.Request) {
 	var siteMapIndexObj SitemapIndex
 	var newsObj News
 
-	resp, _ := http.Get("https://www.washingtonpost.com/news-sitemaps/index.xml")
-	bytes, _ := ioutil.ReadAll(resp.Body)
-	xml.Unmarshal(bytes, &siteMapIndexObj)
-	resp.Body.Close()
+	if err := fetchXML(*sitemapIndexURL, &siteMapIndexObj); err != nil {
+		slog.Error("fetching sitemap index failed", "err", err, "request_id", web.RequestIDFrom(r.Context()))
+		http.Error(w, "Could not fetch the news sitemaps", http.StatusBadGateway)
+		return
+	}
 
 	newsMap := make(map[string]NewsMap)
 
 	for _, Location := range siteMapIndexObj.Locations {
-		resp, _ := http.Get(strings.TrimSpace(Location))
-		bytes, _ := ioutil.ReadAll(resp.Body)
-		xml.Unmarshal(bytes, &newsObj)
-		resp.Body.Close()
+		newsObj = News{}
+		if err := fetchXML(strings.TrimSpace(Location), &newsObj); err != nil {
+			// One broken sitemap shouldn't take the whole page down
+			slog.Warn("skipping sitemap", "source", Location, "err", err)
+			continue
+		}
 		// Put Data into NewsMap
 		for idx := range newsObj.Titles {
 			newsMap[newsObj.Titles[idx]] = NewsMap{newsObj.Keywords[idx], newsObj.Locations[idx]}
 		}
 	}
 
+	refreshesTotal.Inc()
+	refreshArticles.Set(float64(len(newsMap)))
+
 	// NewsMap contains all the data we want
+	// Only logged at debug level, this is one line per article
 	for title, data := range newsMap {
-		fmt.Println("\n\n\nTitle: ", title)
-		fmt.Println("\nKeywords: ", data.Keyword)
-		fmt.Println("\nLocation: ", data.Location)
-	}
-	// Build the page
-	p := NewsAggPage{Title: "Amazing News Agg Page", News: newsMap}
-	t, err := template.ParseFiles("newsaggtemplate.html")
-
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		slog.Debug("article", "title", title, "keywords", data.Keyword, "location", data.Location)
 	}
+	// Build the page, sorting and paging is done here instead of in the browser
+	p := NewsAggPage{Title: *pageTitle, Query: parseTableQuery(r.URL.Query())}
+	p.Rows, p.Total, p.Pages = buildRows(newsMap, &p.Query)
 	// Execute the page
-	t.Execute(w, p)
+	pages.Render(w, "newsaggtemplate.html", p)
 
 }
 
+// checkNewsConfig validates the aggregator's own settings
+func checkNewsConfig() error {
+	var errs []error
+	if err := web.CheckURL("sitemap-index", *sitemapIndexURL); err != nil {
+		errs = append(errs, err)
+	}
+	if *pageTitle == "" {
+		errs = append(errs, errors.New("--title can't be empty"))
+	}
+	return errors.Join(errs...)
+}
+
 func main() {
+	// A config file and NEWSAGG_* env vars fill in any flags not given
+	web.Setup("NEWSAGG_", checkNewsConfig)
+
+	// Parse the templates once, not on every request
+	var err error
+	pages, err = loadTemplates()
+	if err != nil {
+		web.Fatal("loading templates failed", err)
+	}
+
+	// Routes match on method and path, anything else gets a 404 or 405 page
+	mux := web.NewRouter(pages)
 
 	// Vid 17
-	http.HandleFunc("/agg/", newsAggHandler)
+	mux.HandleFunc("GET /agg/{$}", newsAggHandler)
+	mux.Handle("GET /static/", staticHandler())
+	mux.Handle("GET /metrics", web.MetricsHandler())
 
 	// All function handlers should come before this
-	// Direct WS to listen on Port, nil handler, DefaultServeMux used
-	http.ListenAndServe(":8080", nil)
-
+	// Runs until interrupted, exits non-zero if the port can't be bound
+	web.Run(mux)
 }
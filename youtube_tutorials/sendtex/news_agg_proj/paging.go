@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rows shown on one page of the table, and the most a client may ask for
+const (
+	defaultPerPage = 25
+	maxPerPage     = 200
+)
+
+// NewsRow is one row of the rendered news table
+type NewsRow struct {
+	Title    string
+	Keyword  string
+	Location string
+}
+
+// tableQuery holds the search, sort and paging options read from the URL
+type tableQuery struct {
+	Search  string
+	Sort    string // "title" or "keyword"
+	Order   string // "asc" or "desc"
+	Page    int
+	PerPage int
+}
+
+func parseTableQuery(v url.Values) tableQuery {
+	q := tableQuery{
+		Search:  strings.TrimSpace(v.Get("q")),
+		Sort:    "title",
+		Order:   "asc",
+		Page:    1,
+		PerPage: defaultPerPage,
+	}
+	if v.Get("sort") == "keyword" {
+		q.Sort = "keyword"
+	}
+	if v.Get("order") == "desc" {
+		q.Order = "desc"
+	}
+	if n, err := strconv.Atoi(v.Get("page")); err == nil && n > 0 {
+		q.Page = n
+	}
+	if n, err := strconv.Atoi(v.Get("per")); err == nil && n > 0 {
+		q.PerPage = min(n, maxPerPage)
+	}
+	return q
+}
+
+// Encode the query back into a URL query string
+func (q tableQuery) encode() string {
+	v := url.Values{}
+	if q.Search != "" {
+		v.Set("q", q.Search)
+	}
+	v.Set("sort", q.Sort)
+	v.Set("order", q.Order)
+	v.Set("page", strconv.Itoa(q.Page))
+	if q.PerPage != defaultPerPage {
+		v.Set("per", strconv.Itoa(q.PerPage))
+	}
+	return "?" + v.Encode()
+}
+
+// buildRows filters, sorts and pages the news map into table rows
+// Returns the rows for the requested page, the number of matches and the number of pages
+func buildRows(newsMap map[string]NewsMap, q *tableQuery) ([]NewsRow, int, int) {
+	search := strings.ToLower(q.Search)
+	rows := make([]NewsRow, 0, len(newsMap))
+	for title, data := range newsMap {
+		if search != "" &&
+			!strings.Contains(strings.ToLower(title), search) &&
+			!strings.Contains(strings.ToLower(data.Keyword), search) {
+			continue
+		}
+		rows = append(rows, NewsRow{Title: title, Keyword: data.Keyword, Location: data.Location})
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i].Title, rows[j].Title
+		if q.Sort == "keyword" && rows[i].Keyword != rows[j].Keyword {
+			a, b = rows[i].Keyword, rows[j].Keyword
+		}
+		if q.Order == "desc" {
+			return a > b
+		}
+		return a < b
+	})
+
+	// Clamp the page so an out of range page shows the last one
+	total := len(rows)
+	pages := max((total+q.PerPage-1)/q.PerPage, 1)
+	q.Page = min(q.Page, pages)
+
+	start := (q.Page - 1) * q.PerPage
+	end := min(start+q.PerPage, total)
+	return rows[start:end], total, pages
+}
+
+// SortLink returns the link for a column header, flipping the order if already sorted on it
+func (p NewsAggPage) SortLink(col string) string {
+	q := p.Query
+	order := "asc"
+	if q.Sort == col && q.Order == "asc" {
+		order = "desc"
+	}
+	q.Sort, q.Order, q.Page = col, order, 1
+	return q.encode()
+}
+
+// SortMark shows which way a column is sorted
+func (p NewsAggPage) SortMark(col string) string {
+	if p.Query.Sort != col {
+		return ""
+	}
+	if p.Query.Order == "desc" {
+		return "▼"
+	}
+	return "▲"
+}
+
+// AriaSort is the aria-sort value for a column header
+func (p NewsAggPage) AriaSort(col string) string {
+	if p.Query.Sort != col {
+		return "none"
+	}
+	if p.Query.Order == "desc" {
+		return "descending"
+	}
+	return "ascending"
+}
+
+// PageLink returns the link to page n keeping the current search and sort
+func (p NewsAggPage) PageLink(n int) string {
+	q := p.Query
+	q.Page = n
+	return q.encode()
+}
+
+// HasPrev ...
+func (p NewsAggPage) HasPrev() bool { return p.Query.Page > 1 }
+
+// HasNext ...
+func (p NewsAggPage) HasNext() bool { return p.Query.Page < p.Pages }
+
+// PrevPage ...
+func (p NewsAggPage) PrevPage() int { return p.Query.Page - 1 }
+
+// NextPage ...
+func (p NewsAggPage) NextPage() int { return p.Query.Page + 1 }
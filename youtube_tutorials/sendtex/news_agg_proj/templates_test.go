@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go-learning/youtube_tutorials/sendtex/internal/htmltest"
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+var sampleNews = map[string]NewsMap{
+	"Senate passes the budget bill":   {Keyword: "politics, budget", Location: "https://news.example.com/politics/budget"},
+	"Le budget adopté <enfin> & vite": {Keyword: "politique", Location: "https://news.example.com/fr/budget?x=1&y=2"},
+}
+
+// samplePages is every page with data close to what the handlers pass,
+// including the empty /agg/ page before any sitemap has loaded
+func samplePages() map[string][]any {
+	full := NewsAggPage{Title: "News", Query: parseTableQuery(url.Values{"q": {"budget"}, "sort": {"keyword"}})}
+	full.Rows, full.Total, full.Pages = buildRows(sampleNews, &full.Query)
+
+	empty := NewsAggPage{Title: "News", Query: parseTableQuery(nil)}
+	empty.Rows, empty.Total, empty.Pages = buildRows(nil, &empty.Query)
+
+	return map[string][]any{
+		"newsaggtemplate.html": {full, empty},
+		"error.html": {
+			web.ErrorPage{Title: "Not Found", Status: 404, Message: "No page here.", Path: "/nope"},
+		},
+	}
+}
+
+func TestTemplatesRender(t *testing.T) {
+	ts, err := loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for page, datas := range samplePages() {
+		for i, data := range datas {
+			var buf bytes.Buffer
+			if err := ts.Execute(&buf, page, data); err != nil {
+				t.Errorf("%s #%d: %v", page, i, err)
+				continue
+			}
+			if !strings.Contains(buf.String(), "</html>") {
+				t.Errorf("%s #%d: rendered page isn't a whole document", page, i)
+			}
+		}
+	}
+}
+
+func TestAggPageBalanced(t *testing.T) {
+	ts, err := loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range samplePages()["newsaggtemplate.html"] {
+		var buf bytes.Buffer
+		if err := ts.Execute(&buf, "newsaggtemplate.html", data); err != nil {
+			t.Fatal(err)
+		}
+		if err := htmltest.CheckBalanced(buf.String()); err != nil {
+			t.Errorf("newsaggtemplate.html #%d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"io/fs"
+	"os"
+	"time"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+// Templates are compiled into the binary
+
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	templateDir = flag.String("templates", "", "load templates from this dir instead of the embedded copy")
+	devMode     = flag.Bool("dev", false, "watch the template files and reload them on change")
+)
+
+// Every page is parsed together with the layout and the partials
+var sharedTemplates = []string{"layout.html", "partials/*.html"}
+
+// Parsed once in main, shared by all handlers
+var pages *web.TemplateSet
+
+// Load the templates from disk if asked to, else from the embedded copy
+// Dev mode defaults to ./templates so edits show up without a rebuild
+func loadTemplates() (*web.TemplateSet, error) {
+	fsys, _ := fs.Sub(templateFS, "templates")
+	dir := *templateDir
+	if dir == "" && *devMode {
+		dir = "templates"
+	}
+	if dir != "" {
+		fsys = os.DirFS(dir)
+	}
+
+	ts, err := web.NewTemplateSet(fsys, sharedTemplates, "index.html", "about.html", "basictemplating.html", "error.html")
+	if err != nil {
+		return nil, err
+	}
+	if *devMode {
+		go ts.Watch(dir, 500*time.Millisecond)
+	}
+	return ts, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
+)
+
+func TestTemplatesRender(t *testing.T) {
+	ts, err := loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := map[string]any{
+		"index.html":           basicPage{Title: "Home"},
+		"about.html":           basicPage{Title: "About"},
+		"basictemplating.html": NewsAggPage{Title: "News", News: "some <b>news</b>"},
+		"error.html":           web.ErrorPage{Title: "Not Found", Status: 404, Message: "No page here.", Path: "/nope"},
+	}
+	for page, data := range pages {
+		var buf bytes.Buffer
+		if err := ts.Execute(&buf, page, data); err != nil {
+			t.Errorf("%s: %v", page, err)
+			continue
+		}
+		if !strings.Contains(buf.String(), "</html>") {
+			t.Errorf("%s: rendered page isn't a whole document", page)
+		}
+	}
+}
@@ -1,18 +1,27 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"html/template"
 	"net/http"
+
+	"go-learning/youtube_tutorials/sendtex/internal/web"
 )
 
+// basicPage is the data for pages that only need a title
+type basicPage struct {
+	Title string
+}
+
 // Args are the Response Writer and the HTTP Request
 func indexHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, "Whoa, Nice!")
+	// Pages are wrapped in the shared layout
+	pages.Render(w, "index.html", basicPage{Title: "Home"})
 }
 
 func aboutHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, "This is the About Page")
+	pages.Render(w, "about.html", basicPage{Title: "About"})
 }
 
 // Vid 9
@@ -38,37 +47,55 @@ type NewsAggPage struct {
 	News  string
 }
 
+var pageTitle = flag.String("title", "Amazing News Agg Page", "title of the /agg/ page")
+
 func newsAggHandler(w http.ResponseWriter, r *http.Request) {
 	// Build the page
-	p := NewsAggPage{Title: "Amazing News Agg Page", News: "some news"}
-	t, err := template.ParseFiles("basictemplating.html")
-
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
+	p := NewsAggPage{Title: *pageTitle, News: "some news"}
 	// Execute the page
-	t.Execute(w, p)
+	pages.Render(w, "basictemplating.html", p)
 
 }
 
+// checkWebConfig validates the web server's own settings
+func checkWebConfig() error {
+	if *pageTitle == "" {
+		return errors.New("--title can't be empty")
+	}
+	return nil
+}
+
 func main() {
+	// A config file and WEBSERVER_* env vars fill in any flags not given
+	web.Setup("WEBSERVER_", checkWebConfig)
+
+	// Parse the templates once, not on every request
+	var err error
+	pages, err = loadTemplates()
+	if err != nil {
+		web.Fatal("loading templates failed", err)
+	}
+
+	// Routes match on method and path, anything else gets a 404 or 405 page
+	mux := web.NewRouter(pages)
 
 	// Vid 5
 
 	// Similar to bottle request, function to handle path
-	http.HandleFunc("/", indexHandler)
+	// {$} matches only "/" itself, not every unknown path
+	mux.HandleFunc("GET /{$}", indexHandler)
 	// Another url handler
-	http.HandleFunc("/about/", aboutHandler)
+	mux.HandleFunc("GET /about/{$}", aboutHandler)
 
 	// Vid 9
-	http.HandleFunc("/html/", htmlHandler)
+	mux.HandleFunc("GET /html/{$}", htmlHandler)
 
 	// Vid 16
-	http.HandleFunc("/agg/", newsAggHandler)
+	mux.HandleFunc("GET /agg/{$}", newsAggHandler)
 
-	// All function handlers should come before this
-	// Direct WS to listen on Port, nil handler, DefaultServeMux used
-	http.ListenAndServe(":8080", nil)
+	mux.Handle("GET /metrics", web.MetricsHandler())
 
+	// All function handlers should come before this
+	// Runs until interrupted, exits non-zero if the port can't be bound
+	web.Run(mux)
 }